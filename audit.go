@@ -0,0 +1,359 @@
+package weibo
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	AuditOK       = "ok"
+	AuditMissing  = "missing"  // 未下载
+	AuditCorrupt  = "corrupt"  // 无法解码或大小异常
+	AuditRepaired = "repaired" // 已重新下载
+	AuditFailed   = "failed"   // 重新下载失败
+)
+
+// 小于此大小的图片视为损坏
+const minImageSize = 64
+
+// StoredPic 数据库中记录的一张博文图片
+type StoredPic struct {
+	UID       int64
+	ID        int64
+	MblogID   string
+	CreatedAt time.Time
+	Idx       int
+	URL       string
+}
+
+// Pid 从图片地址中取出pid
+func (pic *StoredPic) Pid() string {
+	if u, err := url.Parse(pic.URL); err == nil {
+		name := path.Base(u.Path)
+		return strings.TrimSuffix(name, path.Ext(name))
+	}
+	return ""
+}
+
+func (pic *StoredPic) job() *DownloadJob {
+	key := &MediaKey{UID: pic.UID, ID: pic.ID, MblogID: pic.MblogID, CreatedAt: pic.CreatedAt, Idx: pic.Idx}
+	return &DownloadJob{Kind: JobPic, Name: pic.Pid(), URL: pic.URL, Key: key}
+}
+
+// StoredPics 返回post_media中记录的全部图片
+func (database *Database) StoredPics() ([]*StoredPic, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT p.UID, p.ID, p.MblogID, p.CreatedAt, pm.Idx, m.URL FROM post_media pm " +
+		"JOIN post p ON p.ID = pm.PostID JOIN media m ON m.Pid = pm.Pid ORDER BY p.ID, pm.Idx")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pics []*StoredPic
+	for rows.Next() {
+		pic := &StoredPic{}
+		var uid sql.NullInt64
+		var createdAt legacyTime
+		var picUrl sql.NullString
+		if err := rows.Scan(&uid, &pic.ID, &pic.MblogID, &createdAt, &pic.Idx, &picUrl); err != nil {
+			return nil, err
+		}
+		// 与下载时的MediaKey一致，没有作者时uid为-1
+		pic.UID, pic.CreatedAt, pic.URL = -1, createdAt.Time, picUrl.String
+		if uid.Valid {
+			pic.UID = uid.Int64
+		}
+		pics = append(pics, pic)
+	}
+	return pics, rows.Err()
+}
+
+// CheckImage 检查存储中的图片能否完整解码
+func CheckImage(store MediaStore, key string) error {
+	r, err := store.Open(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < minImageSize {
+		return fmt.Errorf("size %d: %w", len(data), InvalidMedia)
+	}
+	switch sniffExt(data) {
+	case ".jpg", ".png", ".gif":
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", err, InvalidMedia)
+		}
+		if b := img.Bounds(); b.Dx() == 0 || b.Dy() == 0 {
+			return fmt.Errorf("empty image: %w", InvalidMedia)
+		}
+	case "":
+		return fmt.Errorf("not an image: %w", InvalidMedia)
+	}
+	// webp、heic只检查文件头
+	return nil
+}
+
+type AuditItem struct {
+	Pic    *StoredPic
+	Key    string // 存储中的key，未下载时为空
+	Status string
+	Err    error
+}
+
+type AuditReport struct {
+	Items []*AuditItem
+}
+
+func (r *AuditReport) Count(status string) int {
+	n := 0
+	for _, item := range r.Items {
+		if item.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Problems 返回状态不是ok的结果
+func (r *AuditReport) Problems() []*AuditItem {
+	var items []*AuditItem
+	for _, item := range r.Items {
+		if item.Status != AuditOK {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (r *AuditReport) String() string {
+	return fmt.Sprintf("%d pics, %d ok, %d missing, %d corrupt, %d repaired, %d failed", len(r.Items),
+		r.Count(AuditOK), r.Count(AuditMissing), r.Count(AuditCorrupt), r.Count(AuditRepaired), r.Count(AuditFailed))
+}
+
+// Auditor 核对数据库记录的图片与媒体存储，Repair时重新下载缺失或损坏的图片，
+// 原地址失效时通过GetMblog获取新的地址
+type Auditor struct {
+	Database   *Database
+	Downloader *Downloader // 使用其Store或Dedup.Store
+	Repair     bool
+	Progress   func(item *AuditItem)
+}
+
+func (a *Auditor) store() (MediaStore, error) {
+	if a.Downloader.Dedup != nil {
+		return a.Downloader.Dedup.Store, nil
+	}
+	if a.Downloader.Store == nil {
+		return nil, fmt.Errorf("audit requires a media store")
+	}
+	return a.Downloader.Store, nil
+}
+
+func (a *Auditor) Run() (*AuditReport, error) {
+	store, err := a.store()
+	if err != nil {
+		return nil, err
+	}
+	pics, err := a.Database.StoredPics()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{}
+	for _, pic := range pics {
+		item := a.check(store, pic)
+		if a.Repair && item.Status != AuditOK {
+			a.repair(store, item)
+		}
+		report.Items = append(report.Items, item)
+		if a.Progress != nil {
+			a.Progress(item)
+		}
+	}
+	return report, nil
+}
+
+func (a *Auditor) check(store MediaStore, pic *StoredPic) *AuditItem {
+	item := &AuditItem{Pic: pic}
+	job := pic.job()
+	var key string
+	var ok bool
+	var err error
+	if a.Downloader.Dedup != nil {
+		key, ok, err = a.findBlob(store, job)
+	} else {
+		key, ok, err = findKey(store, job.key(), picExts)
+	}
+	if err != nil {
+		item.Status, item.Err = AuditFailed, err
+		return item
+	}
+	if !ok {
+		item.Status = AuditMissing
+		return item
+	}
+	item.Key = key
+	if err := CheckImage(store, key); err != nil {
+		item.Status, item.Err = AuditCorrupt, err
+		return item
+	}
+	item.Status = AuditOK
+	return item
+}
+
+// findBlob 返回去重存储中图片的key：按pid找到blob并且有对应的引用，
+// 支持硬链接的存储检查布局路径，否则检查blob本身
+func (a *Auditor) findBlob(store MediaStore, job *DownloadJob) (string, bool, error) {
+	dedup := a.Downloader.Dedup
+	blob, err := dedup.Lookup(job.Name)
+	if err != nil || blob == nil {
+		return "", false, err
+	}
+	key := job.key()
+	key.Ext = blob.Ext
+	refKey := store.Key(key)
+	if ok, err := dedup.Database.HasMediaKey(refKey); err != nil || !ok {
+		return "", false, err
+	}
+	if _, ok := store.(Linker); !ok {
+		refKey = blob.Key
+	}
+	ok, err := store.Exists(refKey)
+	return refKey, ok, err
+}
+
+func (a *Auditor) repair(store MediaStore, item *AuditItem) {
+	var corrupt *MediaBlob
+	if item.Status == AuditCorrupt {
+		var err error
+		if corrupt, err = a.forget(store, item); err != nil {
+			item.Status, item.Err = AuditFailed, err
+			return
+		}
+	}
+
+	job := item.Pic.job()
+	result := a.Downloader.Download([]*DownloadJob{job}).Results[0]
+	if result.Err != nil && a.Downloader.Client != nil && item.Pic.MblogID != "" {
+		// 图片地址可能已过期，重新获取博文
+		if job, err := a.refresh(item.Pic); err != nil {
+			result.Err = errors.Join(result.Err, err)
+		} else if job != nil {
+			result = a.Downloader.Download([]*DownloadJob{job}).Results[0]
+		}
+	}
+	if result.Err != nil {
+		if corrupt != nil {
+			// 下载失败时恢复pid的记录，其他引用不受影响
+			result.Err = errors.Join(result.Err, a.Downloader.Dedup.Database.AddBlobPid(item.Pic.Pid(), corrupt.SHA256))
+		}
+		item.Status, item.Err = AuditFailed, result.Err
+		return
+	}
+	if corrupt != nil {
+		if err := a.relink(corrupt, result.SHA256); err != nil {
+			item.Status, item.Err = AuditFailed, err
+			return
+		}
+	}
+	item.Status, item.Key, item.Err = AuditRepaired, result.File, nil
+}
+
+// forget 删除损坏的文件；去重存储中blob可能被其他博文引用，只解除pid与blob的关联，
+// 使重新下载存为新的blob，返回损坏的blob
+func (a *Auditor) forget(store MediaStore, item *AuditItem) (*MediaBlob, error) {
+	dedup := a.Downloader.Dedup
+	if dedup == nil {
+		return nil, store.Delete(item.Key)
+	}
+	blob, err := dedup.Lookup(item.Pic.Pid())
+	if err != nil || blob == nil {
+		return nil, err
+	}
+	return blob, dedup.Database.DeleteBlobPid(item.Pic.Pid())
+}
+
+// relink 将损坏blob的全部引用和pid改为指向重新下载的blob，之后删除损坏的blob
+func (a *Auditor) relink(corrupt *MediaBlob, sha256 string) error {
+	dedup := a.Downloader.Dedup
+	if sha256 == corrupt.SHA256 {
+		return nil
+	}
+	blob, err := dedup.Database.Blob(sha256)
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		return fmt.Errorf("blob %s not found", sha256)
+	}
+	refs, err := dedup.Database.BlobRefs(corrupt.SHA256)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		// 类型变化时布局路径的扩展名随之改变
+		if blob.Ext != corrupt.Ext && strings.HasSuffix(ref.Key, "."+corrupt.Ext) {
+			if err := dedup.Database.DeleteMediaRef(ref); err != nil {
+				return err
+			}
+			if _, ok := dedup.Store.(Linker); ok {
+				if err := dedup.Store.Delete(ref.Key); err != nil {
+					return err
+				}
+			}
+			ref.Key = strings.TrimSuffix(ref.Key, corrupt.Ext) + blob.Ext
+		}
+		if err := dedup.Link(blob, ref); err != nil {
+			return err
+		}
+	}
+	pids, err := dedup.Database.BlobPids(corrupt.SHA256)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := dedup.Database.AddBlobPid(pid, blob.SHA256); err != nil {
+			return err
+		}
+	}
+	return dedup.deleteBlob(corrupt)
+}
+
+// refresh 通过GetMblog获取图片的新下载任务，博文中已没有该图片时返回nil
+func (a *Auditor) refresh(pic *StoredPic) (*DownloadJob, error) {
+	mblog, err := a.Downloader.Client.GetMblog(pic.MblogID)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := PicJobs(mblog, "")
+	for _, job := range jobs {
+		if job.Kind == JobPic && job.Name == pic.Pid() && job.Key.ID == pic.ID {
+			// 沿用原记录的路径信息
+			job.Key = pic.job().Key
+			return job, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,129 @@
+package weibo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 去重存储在不支持硬链接的S3上只有blobs/下的文件
+func TestAuditDedupS3(t *testing.T) {
+	fake := &fakeS3{t: t, bucket: "weibo", accessKey: "AK", secretKey: "SK", pageSize: 100, objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	store := &S3Store{Endpoint: srv.URL, Bucket: "weibo", AccessKey: "AK", SecretKey: "SK",
+		Layout: "{uid}/{yyyy}/{mm}/{mblogid}_{idx}.{ext}", Client: srv.Client()}
+	database := newTestDatabase(t)
+	dedup := &DedupStore{Store: store, Database: database}
+
+	post := &Post{ID: 1, MblogID: "abc", Author: &User{ID: 2}, CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, ChinaTimeZone),
+		Pictures: []*PostMedia{{ID: "pid1", Type: "pic", URL: "https://wx1.sinaimg.cn/large/pid1.jpg"}}}
+	if err := database.AddPost(post); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)))
+	file := filepath.Join(t.TempDir(), "pid1.png")
+	os.WriteFile(file, buf.Bytes(), 0644)
+	sum := sha256.Sum256(buf.Bytes())
+	blob, err := dedup.Add(file, &FetchResult{File: file, Size: int64(buf.Len()), SHA256: hex.EncodeToString(sum[:])}, "pid1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pics, err := database.StoredPics()
+	if err != nil || len(pics) != 1 {
+		t.Fatalf("StoredPics() = %v, %v", pics, err)
+	}
+	key := pics[0].job().key()
+	key.Ext = blob.Ext
+	if err := dedup.Link(blob, &MediaRef{ID: post.ID, Kind: JobPic, Key: store.Key(key)}); err != nil {
+		t.Fatal(err)
+	}
+
+	auditor := &Auditor{Database: database, Downloader: &Downloader{Dedup: dedup}}
+	report, err := auditor.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Items) != 1 || report.Items[0].Status != AuditOK || report.Items[0].Key != blob.Key {
+		t.Errorf("audit = %+v, want ok", report.Items[0])
+	}
+
+	store.Delete(blob.Key)
+	if report, err = auditor.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if report.Items[0].Status != AuditMissing {
+		t.Errorf("audit after deleting the blob = %s, want missing", report.Items[0].Status)
+	}
+}
+
+// 损坏的blob被多个博文引用时，重新下载后全部引用指向新的blob
+func TestAuditRepairSharedBlob(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	fake := &fakeS3{t: t, bucket: "weibo", accessKey: "AK", secretKey: "SK", pageSize: 100, objects: map[string][]byte{}}
+	s3 := httptest.NewServer(fake)
+	defer s3.Close()
+	store := &S3Store{Endpoint: s3.URL, Bucket: "weibo", AccessKey: "AK", SecretKey: "SK", Layout: "{uid}/{mblogid}_{idx}.{ext}", Client: s3.Client()}
+	database := newTestDatabase(t)
+	dedup := &DedupStore{Store: store, Database: database}
+
+	// 两个博文使用同一张图片，blob的内容已损坏
+	corrupt := []byte("corrupt")
+	sum := sha256.Sum256(corrupt)
+	file := filepath.Join(t.TempDir(), "pid1.jpg")
+	os.WriteFile(file, corrupt, 0644)
+	blob, err := dedup.Add(file, &FetchResult{File: file, Size: int64(len(corrupt)), SHA256: hex.EncodeToString(sum[:])}, "pid1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{1, 2} {
+		post := &Post{ID: id, MblogID: fmt.Sprint("m", id), Author: &User{ID: 3}, Pictures: []*PostMedia{{ID: "pid1", Type: "pic", URL: srv.URL + "/large/pid1.jpg"}}}
+		if err := database.AddPost(post); err != nil {
+			t.Fatal(err)
+		}
+		key := &MediaKey{UID: 3, ID: id, MblogID: post.MblogID, Ext: blob.Ext}
+		if err := dedup.Link(blob, &MediaRef{ID: id, Kind: JobPic, Key: store.Key(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	auditor := &Auditor{Database: database, Downloader: &Downloader{Client: &Client{}, Dedup: dedup, Retries: -1}, Repair: true}
+	report, err := auditor.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Items) != 2 || report.Items[0].Status != AuditRepaired || report.Items[1].Status != AuditOK {
+		t.Fatalf("audit = %s, want the first repaired and the second ok", report)
+	}
+	repaired, err := dedup.Lookup("pid1")
+	if err != nil || repaired == nil || repaired.SHA256 == blob.SHA256 {
+		t.Fatalf("Lookup = %v, %v, want a new blob", repaired, err)
+	}
+	if refs, err := database.BlobRefs(repaired.SHA256); err != nil || len(refs) != 2 {
+		t.Errorf("BlobRefs = %v, %v, want both posts", refs, err)
+	}
+	if ok, _ := store.Exists(blob.Key); ok {
+		t.Errorf("corrupt blob kept")
+	}
+	if report, err = auditor.Run(); err != nil || report.Count(AuditOK) != 2 {
+		t.Errorf("audit after repair = %v, %v", report, err)
+	}
+}
@@ -0,0 +1,200 @@
+package weibo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 微博上线时间，全量回溯默认的最早时间
+var WeiboEpoch = time.Date(2009, 8, 1, 0, 0, 0, 0, time.FixedZone("CST", 8*3600))
+
+// Checkpoint 记录单个用户全量回溯的进度
+type Checkpoint struct {
+	UID       string    `json:"uid"`
+	Since     time.Time `json:"since"`      // 回溯的最早时间
+	Until     time.Time `json:"until"`      // 回溯的起始（最晚）时间
+	Start     time.Time `json:"start"`      // 当前时间窗口起点
+	End       time.Time `json:"end"`        // 当前时间窗口终点
+	Page      int       `json:"page"`       // 当前窗口下一个待抓取的页
+	LastID    int64     `json:"last_id"`    // 最后处理的博文id
+	Count     int       `json:"count"`      // 已处理的博文数
+	Done      bool      `json:"done"`       // 是否已完成
+	UpdatedAt time.Time `json:"updated_at"` // 最后更新时间
+}
+
+// Progress 返回回溯进度，取值0~1
+func (cp *Checkpoint) Progress() float64 {
+	if cp.Done {
+		return 1
+	}
+	total := cp.Until.Sub(cp.Since)
+	if total <= 0 {
+		return 0
+	}
+	return float64(cp.Until.Sub(cp.End)) / float64(total)
+}
+
+func (cp *Checkpoint) String() string {
+	return fmt.Sprintf("%s | %s ~ %s | page=%d | last_id=%d | count=%d | %.1f%%",
+		cp.UID, cp.Start.Format("2006-01-02"), cp.End.Format("2006-01-02"), cp.Page, cp.LastID, cp.Count, cp.Progress()*100)
+}
+
+type CheckpointStore interface {
+	// Load 返回uid的检查点，不存在时返回nil
+	Load(uid string) (*Checkpoint, error)
+	Save(cp *Checkpoint) error
+}
+
+// FileCheckpointStore 将检查点以json文件保存在Dir目录下，每个uid一个文件
+type FileCheckpointStore struct {
+	Dir string
+}
+
+func (s *FileCheckpointStore) path(uid string) string {
+	return filepath.Join(s.Dir, uid+".json")
+}
+
+func (s *FileCheckpointStore) Load(uid string) (*Checkpoint, error) {
+	data, err := os.ReadFile(s.path(uid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (s *FileCheckpointStore) Save(cp *Checkpoint) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path(cp.UID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(cp.UID))
+}
+
+// Backfill 按时间窗口从新到旧回溯用户的全部博文，绕过翻页深度限制，
+// 每抓取一页保存一次检查点，出错（如cookie失效）后重新运行即可从断点继续
+type Backfill struct {
+	Client      *Client
+	Checkpoints CheckpointStore
+	Since       time.Time                   // 最早时间，默认WeiboEpoch
+	Window      time.Duration               // 时间窗口大小，默认30天
+	Sleep       time.Duration               // 每页之间的间隔
+	Feature     Feature                     // 博文类型筛选，默认全部
+	Longtext    bool                        // 是否获取长文本
+	Handle      func(mblogs []*Mblog) error // 处理每页抓取到的博文
+	Progress    func(cp *Checkpoint)        // 每页处理完成后回调
+	Now         func() time.Time            // 默认time.Now
+}
+
+func (b *Backfill) checkpoint(uid string) (*Checkpoint, error) {
+	cp, err := b.Checkpoints.Load(uid)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		return cp, nil
+	}
+
+	now := time.Now
+	if b.Now != nil {
+		now = b.Now
+	}
+	since := b.Since
+	if since.IsZero() {
+		since = WeiboEpoch
+	}
+	window := b.Window
+	if window <= 0 {
+		window = 30 * 24 * time.Hour
+	}
+	until := now()
+	start := until.Add(-window)
+	if start.Before(since) {
+		start = since
+	}
+	return &Checkpoint{
+		UID:   uid,
+		Since: since,
+		Until: until,
+		Start: start,
+		End:   until,
+		Page:  1,
+	}, nil
+}
+
+func (b *Backfill) save(cp *Checkpoint) error {
+	cp.UpdatedAt = time.Now()
+	return b.Checkpoints.Save(cp)
+}
+
+// Run 回溯uid的博文直到Since，已完成的检查点直接返回
+func (b *Backfill) Run(uid string) error {
+	cp, err := b.checkpoint(uid)
+	if err != nil {
+		return err
+	}
+	window := b.Window
+	if window <= 0 {
+		window = 30 * 24 * time.Hour
+	}
+
+	for !cp.Done {
+		mblogs, err := b.Client.SearchMblogs(uid, cp.Page, cp.Start, cp.End, b.Feature, b.Longtext)
+		if err != nil {
+			if serr := b.save(cp); serr != nil {
+				return serr
+			}
+			return fmt.Errorf("backfill %s: %w", cp, err)
+		}
+
+		if len(mblogs) > 0 {
+			if b.Handle != nil {
+				if err := b.Handle(mblogs); err != nil {
+					if serr := b.save(cp); serr != nil {
+						return serr
+					}
+					return fmt.Errorf("backfill %s: %w", cp, err)
+				}
+			}
+			cp.Page++
+			cp.LastID = mblogs[len(mblogs)-1].ID
+			cp.Count += len(mblogs)
+		} else if !cp.Start.After(cp.Since) {
+			cp.Done = true
+		} else {
+			cp.End = cp.Start
+			cp.Start = cp.End.Add(-window)
+			if cp.Start.Before(cp.Since) {
+				cp.Start = cp.Since
+			}
+			cp.Page = 1
+		}
+
+		if err := b.save(cp); err != nil {
+			return err
+		}
+		if b.Progress != nil {
+			b.Progress(cp)
+		}
+		if !cp.Done && b.Sleep > 0 {
+			time.Sleep(b.Sleep)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package weibo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestClient 返回将全部请求转发到handler的Client，handler通过r.Host区分原本请求的域名
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Host = req.URL.Host
+		req.URL.Scheme = "http"
+		req.URL.Host = srv.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+}
+
+// memoryCheckpoints 只在内存中保存检查点
+type memoryCheckpoints map[string]Checkpoint
+
+func (m memoryCheckpoints) Load(uid string) (*Checkpoint, error) {
+	cp, ok := m[uid]
+	if !ok {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func (m memoryCheckpoints) Save(cp *Checkpoint) error {
+	m[cp.UID] = *cp
+	return nil
+}
+
+func TestBackfillRun(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, ChinaTimeZone)
+	day := 24 * time.Hour
+	window := func(start, end time.Time, page int) string {
+		return fmt.Sprintf("%d-%d-%d", start.Unix(), end.Unix(), page)
+	}
+	// 两个窗口：[now-30d, now]有两页，[now-45d, now-30d]有一页
+	pages := map[string]string{
+		window(now.Add(-30*day), now, 1):              `[{"id":5,"mblogid":"e"},{"id":4,"mblogid":"d"}]`,
+		window(now.Add(-30*day), now, 2):              `[{"id":3,"mblogid":"c"}]`,
+		window(now.Add(-45*day), now.Add(-30*day), 1): `[{"id":2,"mblogid":"b"}]`,
+	}
+	var requests []string
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Host != "weibo.com" || r.URL.Path != "/ajax/statuses/searchProfile" || q.Get("uid") != "1" {
+			t.Errorf("unexpected request %s%s", r.Host, r.URL)
+		}
+		key := q.Get("starttime") + "-" + q.Get("endtime") + "-" + q.Get("page")
+		requests = append(requests, key)
+		// 第3次请求返回400，模拟中断
+		if len(requests) == 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		list := pages[key]
+		if list == "" {
+			list = "[]"
+		}
+		fmt.Fprintf(w, `{"ok":1,"data":{"list":%s}}`, list)
+	}))
+
+	checkpoints := memoryCheckpoints{}
+	var ids []int64
+	b := &Backfill{
+		Client:      client,
+		Checkpoints: checkpoints,
+		Since:       now.Add(-45 * day),
+		Window:      30 * day,
+		Now:         func() time.Time { return now },
+		Handle: func(mblogs []*Mblog) error {
+			for _, mblog := range mblogs {
+				ids = append(ids, mblog.ID)
+			}
+			return nil
+		},
+	}
+	if err := b.Run("1"); !errors.Is(err, BadRequest) {
+		t.Fatalf("Run = %v, want BadRequest", err)
+	}
+	cp := checkpoints["1"]
+	if cp.Page != 3 || cp.LastID != 3 || cp.Count != 3 || cp.Done {
+		t.Errorf("checkpoint after failure = %s", &cp)
+	}
+
+	// 从检查点继续，不重复抓取已处理的页
+	if err := b.Run("1"); err != nil {
+		t.Fatal(err)
+	}
+	cp = checkpoints["1"]
+	if !cp.Done || cp.Count != 4 || cp.Progress() != 1 || !cp.Start.Equal(b.Since) {
+		t.Errorf("checkpoint = %s", &cp)
+	}
+	if got := fmt.Sprint(ids); got != "[5 4 3 2]" {
+		t.Errorf("handled %s, want [5 4 3 2]", got)
+	}
+	want := []string{
+		window(now.Add(-30*day), now, 1),
+		window(now.Add(-30*day), now, 2),
+		window(now.Add(-30*day), now, 3),
+		window(now.Add(-30*day), now, 3),
+		window(now.Add(-45*day), now.Add(-30*day), 1),
+		window(now.Add(-45*day), now.Add(-30*day), 2),
+	}
+	if fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+
+	// 已完成的检查点不再请求
+	if err := b.Run("1"); err != nil || len(requests) != len(want) {
+		t.Errorf("Run after done = %v with %d requests", err, len(requests)-len(want))
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	store := &FileCheckpointStore{Dir: t.TempDir()}
+	if cp, err := store.Load("1"); err != nil || cp != nil {
+		t.Fatalf("Load(missing) = %v, %v", cp, err)
+	}
+	want := &Checkpoint{UID: "1", Page: 2, LastID: 10, Count: 20, Since: WeiboEpoch}
+	if err := store.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := store.Load("1")
+	if err != nil || cp == nil || cp.Page != 2 || cp.LastID != 10 || cp.Count != 20 || !cp.Since.Equal(WeiboEpoch) {
+		t.Errorf("Load = %+v, %v", cp, err)
+	}
+}
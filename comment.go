@@ -1,6 +1,9 @@
 package weibo
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type CommentBody struct {
 	Ok          int           `json:"ok"`
@@ -48,27 +51,30 @@ type Comments struct {
 		Text          string `json:"text"`
 		HighlightText string `json:"highlight_text"`
 	} `json:"more_info"`
-	TextRaw string      `json:"text_raw"`
-	Urls    []UrlStruct `json:"url_struct,omitempty"`
+	TextRaw     string      `json:"text_raw"`
+	Urls        []UrlStruct `json:"url_struct,omitempty"`
+	CreatedTime time.Time   `json:"-"`
+	Fetched     *Fetched    `json:"-"`
 }
 
 type UrlStruct struct {
-	UrlTitle    string                 `json:"url_title"`
-	UrlTypePic  string                 `json:"url_type_pic"`
-	OriUrl      string                 `json:"ori_url"`
-	ShortUrl    string                 `json:"short_url"`
-	LongUrl     string                 `json:"long_url"`
-	UrlType     int                    `json:"url_type"`
-	Result      bool                   `json:"result"`
-	StorageType string                 `json:"storage_type"`
-	Hide        int                    `json:"hide"`
-	ObjectType  string                 `json:"object_type"`
-	Position    int                    `json:"position"`
-	PicInfos    map[string]interface{} `json:"pic_infos"`
-	PicIds      []string               `json:"pic_ids"`
-	GifName     string                 `json:"gif_name"`
-	H5TargetUrl string                 `json:"h5_target_url"`
-	NeedSaveObj int                    `json:"need_save_obj"`
+	UrlTitle    string              `json:"url_title"`
+	UrlTypePic  string              `json:"url_type_pic"`
+	OriUrl      string              `json:"ori_url"`
+	ShortUrl    string              `json:"short_url"`
+	LongUrl     string              `json:"long_url"`
+	UrlType     int                 `json:"url_type"`
+	Result      bool                `json:"result"`
+	StorageType string              `json:"storage_type"`
+	Hide        int                 `json:"hide"`
+	ObjectType  string              `json:"object_type"`
+	Position    int                 `json:"position"`
+	PicInfos    map[string]*PicInfo `json:"pic_infos"`
+	PicIds      []string            `json:"pic_ids"`
+	GifName     string              `json:"gif_name"`
+	H5TargetUrl string              `json:"h5_target_url"`
+	NeedSaveObj int                 `json:"need_save_obj"`
+	PageID      string              `json:"page_id,omitempty"`
 }
 
 // GetComments
@@ -87,6 +93,7 @@ func (c *Client) GetComments(flow int, mid int64, userid string, isMax int, maxI
 	} else if body.Ok != 1 {
 		return nil, fmt.Errorf("body not ok")
 	}
+	c.prepareComments(body.Data)
 	//var mblogs []*Mblog
 	//for _, v := range body.Data.List {
 	//	if longtext {
@@ -103,3 +110,12 @@ func (c *Client) GetComments(flow int, mid int64, userid string, isMax int, maxI
 	//}
 	return body, nil
 }
+
+// GetCommentsByMblogID 同GetComments，使用base62的mblogid
+func (c *Client) GetCommentsByMblogID(flow int, mblogid string, userid string, isMax int, maxId int64, fetchLevel int, longtext bool) (*CommentBody, error) {
+	mid, err := MblogIDToMid(mblogid)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetComments(flow, mid, userid, isMax, maxId, fetchLevel, longtext)
+}
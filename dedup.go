@@ -0,0 +1,461 @@
+package weibo
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MediaBlob 按sha256去重保存的媒体文件
+type MediaBlob struct {
+	SHA256    string
+	Pid       string
+	Ext       string
+	Size      int64
+	Key       string // 在存储中的key
+	CreatedAt time.Time
+}
+
+// MediaRef 博文对媒体文件的引用
+type MediaRef struct {
+	SHA256 string
+	ID     int64  // 博文id
+	Kind   string // pic、motion、video、cover
+	Idx    int
+	Key    string // 按布局生成的key，本地存储中是指向blob的硬链接
+}
+
+// Linker 支持硬链接的存储
+type Linker interface {
+	Link(src, dst string) error
+}
+
+func (s *LocalStore) Link(src, dst string) error {
+	name := s.Path(dst)
+	if err := mkdir(filepath.Dir(name)); err != nil {
+		return err
+	}
+	os.Remove(name)
+	if err := os.Link(s.Path(src), name); err == nil {
+		return nil
+	}
+	// 不支持硬链接时复制一份
+	f, err := os.Open(s.Path(src))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp := name + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, f)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// DedupStore 内容寻址的媒体存储：文件以sha256为key保存一份，按微博pid索引，
+// 每个使用它的博文记录一条引用，本地存储还会在布局路径上建立硬链接
+type DedupStore struct {
+	Store    MediaStore
+	Database *Database
+	Grace    time.Duration // GC不删除写入时间在Grace之内的blob，默认1小时，避免删除下载中还没有记录引用的文件
+}
+
+// DefaultGrace DedupStore.Grace的默认值
+const DefaultGrace = time.Hour
+
+// dedupLocks 按pid或sha256加锁，同一个文件同时只有一个下载在查询和写入
+var dedupLocks keyedMutex
+
+// lock 锁定pid，返回解锁的函数
+func (s *DedupStore) lock(pid string) func() {
+	return dedupLocks.lock(pid)
+}
+
+// ModTimer 可以返回文件写入时间的存储，GC据此跳过刚写入的blob
+type ModTimer interface {
+	ModTime(key string) (time.Time, error)
+}
+
+func (s *LocalStore) ModTime(key string) (time.Time, error) {
+	info, err := os.Stat(s.Path(key))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func BlobKey(sha256 string, ext string) string {
+	return "blobs/" + sha256[:2] + "/" + sha256 + "." + ext
+}
+
+// Lookup 返回pid对应的blob，不存在时返回nil；内容相同的多个pid对应同一个blob
+func (s *DedupStore) Lookup(pid string) (*MediaBlob, error) {
+	return s.Database.BlobByPid(pid)
+}
+
+// Add 将下载完成的文件存为blob并记录pid，内容已存在时丢弃file
+func (s *DedupStore) Add(file string, fetched *FetchResult, pid string) (*MediaBlob, error) {
+	defer s.lock("sha256:" + fetched.SHA256)()
+	ext := strings.TrimPrefix(filepath.Ext(fetched.File), ".")
+	blob, err := s.Database.Blob(fetched.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	if blob != nil {
+		os.Remove(file)
+		if blob.Pid == "" && pid != "" {
+			blob.Pid = pid
+			if err := s.Database.AddBlob(blob); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		blob = &MediaBlob{SHA256: fetched.SHA256, Pid: pid, Ext: ext, Size: fetched.Size, Key: BlobKey(fetched.SHA256, ext), CreatedAt: time.Now()}
+		if err := s.Store.Put(blob.Key, file); err != nil {
+			return nil, err
+		}
+		if err := s.Database.AddBlob(blob); err != nil {
+			return nil, err
+		}
+	}
+	if pid == "" {
+		return blob, nil
+	}
+	return blob, s.Database.AddBlobPid(pid, blob.SHA256)
+}
+
+// Link 记录博文对blob的引用，本地存储同时建立硬链接
+func (s *DedupStore) Link(blob *MediaBlob, ref *MediaRef) error {
+	ref.SHA256 = blob.SHA256
+	if linker, ok := s.Store.(Linker); ok && ref.Key != "" && ref.Key != blob.Key {
+		if err := linker.Link(blob.Key, ref.Key); err != nil {
+			return err
+		}
+	}
+	return s.Database.AddMediaRef(ref)
+}
+
+// GCReport 垃圾回收的结果
+type GCReport struct {
+	Refs  []*MediaRef  // 博文已不存在的引用
+	Blobs []*MediaBlob // 没有引用的blob
+	Stray []string     // 存储中有但数据库中没有记录的blob
+}
+
+// GC 删除博文已不存在的引用和不再被引用的blob，dryRun时只返回将要删除的内容；
+// 写入时间在Grace之内的blob可能还在下载中，不删除。存储没有实现ModTimer时只按数据库记录判断
+func (s *DedupStore) GC(dryRun bool) (*GCReport, error) {
+	report := &GCReport{}
+	grace := s.Grace
+	if grace <= 0 {
+		grace = DefaultGrace
+	}
+	since := time.Now().Add(-grace)
+	refs, err := s.Database.OrphanMediaRefs()
+	if err != nil {
+		return nil, err
+	}
+	report.Refs = refs
+	if !dryRun {
+		for _, ref := range refs {
+			if err := s.Database.DeleteMediaRef(ref); err != nil {
+				return report, err
+			}
+			// 布局中不含博文信息时多个博文共用同一个路径
+			if used, err := s.Database.HasMediaKey(ref.Key); err != nil {
+				return report, err
+			} else if !used && ref.Key != "" && !strings.HasPrefix(ref.Key, "blobs/") {
+				if err := s.Store.Delete(ref.Key); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+
+	blobs, err := s.Database.UnreferencedBlobs()
+	if err != nil {
+		return report, err
+	}
+	for _, blob := range blobs {
+		if blob.CreatedAt.Before(since) {
+			report.Blobs = append(report.Blobs, blob)
+		}
+	}
+	if !dryRun {
+		for _, blob := range report.Blobs {
+			if err := s.deleteBlob(blob); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	keys, err := s.Store.List("blobs/")
+	if err != nil {
+		return report, err
+	}
+	for _, key := range keys {
+		sha := strings.TrimSuffix(filepath.Base(key), filepath.Ext(key))
+		if blob, err := s.Database.Blob(sha); err != nil {
+			return report, err
+		} else if blob == nil {
+			if modTimer, ok := s.Store.(ModTimer); ok {
+				// 取不到时间时可能已被删除，跳过
+				if modTime, err := modTimer.ModTime(key); err != nil || modTime.After(since) {
+					continue
+				}
+			}
+			report.Stray = append(report.Stray, key)
+			if !dryRun {
+				if err := s.Store.Delete(key); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+// deleteBlob 删除没有引用的blob，锁定它的全部pid后再次确认，避免删除正在被下载引用的blob
+func (s *DedupStore) deleteBlob(blob *MediaBlob) error {
+	pids, err := s.Database.BlobPids(blob.SHA256)
+	if err != nil {
+		return err
+	}
+	// 按顺序加锁，下载同时只持有一个pid的锁
+	for _, pid := range pids {
+		defer s.lock(pid)()
+	}
+	if used, err := s.Database.HasBlobRef(blob.SHA256); err != nil || used {
+		return err
+	}
+	if err := s.Store.Delete(blob.Key); err != nil {
+		return err
+	}
+	return s.Database.DeleteBlob(blob.SHA256)
+}
+
+func (database *Database) queryBlob(query string, args ...any) (*MediaBlob, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	blob := &MediaBlob{}
+	var pid sql.NullString
+	var createdAt sql.NullTime
+	err = db.QueryRow(query, args...).Scan(&blob.SHA256, &pid, &blob.Ext, &blob.Size, &blob.Key, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	blob.Pid, blob.CreatedAt = pid.String, createdAt.Time
+	return blob, nil
+}
+
+func (database *Database) Blob(sha256 string) (*MediaBlob, error) {
+	return database.queryBlob("SELECT SHA256, Pid, Ext, Size, BlobKey, CreatedAt FROM media_blob WHERE SHA256 = ?", sha256)
+}
+
+func (database *Database) BlobByPid(pid string) (*MediaBlob, error) {
+	return database.queryBlob("SELECT b.SHA256, b.Pid, b.Ext, b.Size, b.BlobKey, b.CreatedAt FROM media_pid p JOIN media_blob b ON b.SHA256 = p.SHA256 WHERE p.Pid = ?", pid)
+}
+
+// AddBlobPid 记录pid的内容为sha256
+func (database *Database) AddBlobPid(pid string, sha256 string) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(db.dialect.upsert("media_pid", []string{"Pid"}, []string{"SHA256"}), pid, sha256)
+	return err
+}
+
+// DeleteBlobPid 删除pid与blob的关联
+func (database *Database) DeleteBlobPid(pid string) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM media_pid WHERE Pid = ?", pid)
+	return err
+}
+
+// BlobPids 返回内容为sha256的全部pid，按pid排序
+func (database *Database) BlobPids(sha256 string) ([]string, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT Pid FROM media_pid WHERE SHA256 = ? ORDER BY Pid", sha256)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pids []string
+	for rows.Next() {
+		var pid string
+		if err := rows.Scan(&pid); err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, rows.Err()
+}
+
+func (database *Database) AddBlob(blob *MediaBlob) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(db.dialect.upsert("media_blob", []string{"SHA256"}, []string{"Pid", "Ext", "Size", "BlobKey", "CreatedAt"}),
+		blob.SHA256, blob.Pid, blob.Ext, blob.Size, blob.Key, nullTime(blob.CreatedAt))
+	return err
+}
+
+func (database *Database) DeleteBlob(sha256 string) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM media_pid WHERE SHA256 = ?", sha256); err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM media_blob WHERE SHA256 = ?", sha256)
+	return err
+}
+
+func (database *Database) AddMediaRef(ref *MediaRef) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(db.dialect.upsert("media_ref", []string{"ID", "MediaKey"}, []string{"SHA256", "Kind", "Idx"}),
+		ref.ID, ref.Key, ref.SHA256, ref.Kind, ref.Idx)
+	return err
+}
+
+func (database *Database) DeleteMediaRef(ref *MediaRef) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM media_ref WHERE ID = ? AND MediaKey = ?", ref.ID, ref.Key)
+	return err
+}
+
+func (database *Database) HasMediaKey(key string) (bool, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM media_ref WHERE MediaKey = ?", key).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// HasBlobRef 返回blob是否有引用
+func (database *Database) HasBlobRef(sha256 string) (bool, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM media_ref WHERE SHA256 = ?", sha256).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// BlobRefs 返回blob的全部引用
+func (database *Database) BlobRefs(sha256 string) ([]*MediaRef, error) {
+	return database.queryMediaRefs("SELECT ID, MediaKey, SHA256, Kind, Idx FROM media_ref WHERE SHA256 = ?", sha256)
+}
+
+// OrphanMediaRefs 返回博文（包括被转发的博文）已不在post表中的引用
+func (database *Database) OrphanMediaRefs() ([]*MediaRef, error) {
+	return database.queryMediaRefs("SELECT ID, MediaKey, SHA256, Kind, Idx FROM media_ref r WHERE NOT EXISTS (SELECT 1 FROM post p WHERE p.ID = r.ID)")
+}
+
+func (database *Database) queryMediaRefs(query string, args ...any) ([]*MediaRef, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []*MediaRef
+	for rows.Next() {
+		ref := &MediaRef{}
+		if err := rows.Scan(&ref.ID, &ref.Key, &ref.SHA256, &ref.Kind, &ref.Idx); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// UnreferencedBlobs 返回没有任何引用的blob
+func (database *Database) UnreferencedBlobs() ([]*MediaBlob, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT SHA256 FROM media_blob b WHERE NOT EXISTS (SELECT 1 FROM media_ref r WHERE r.SHA256 = b.SHA256)")
+	if err != nil {
+		return nil, err
+	}
+	var shas []string
+	for rows.Next() {
+		var sha string
+		if err := rows.Scan(&sha); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		shas = append(shas, sha)
+	}
+	rows.Close()
+
+	var blobs []*MediaBlob
+	for _, sha := range shas {
+		blob, err := database.Blob(sha)
+		if err != nil {
+			return nil, err
+		}
+		if blob != nil {
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs, nil
+}
@@ -0,0 +1,163 @@
+package weibo
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestDatabase 返回迁移到最新版本的临时sqlite数据库
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	database := &Database{DN: "sqlite", DSN: filepath.Join(t.TempDir(), "weibo.db")}
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(database.Close)
+	return database
+}
+
+func TestDedupGCGrace(t *testing.T) {
+	database := newTestDatabase(t)
+	store := &LocalStore{Root: t.TempDir()}
+	dedup := &DedupStore{Store: store, Database: database}
+
+	// 刚写入还没有记录引用的blob和还没有写入数据库的文件
+	add := func(sha string, createdAt time.Time) *MediaBlob {
+		file := filepath.Join(t.TempDir(), sha+".jpg")
+		os.WriteFile(file, []byte(sha), 0644)
+		blob, err := dedup.Add(file, &FetchResult{File: file, SHA256: sha, Size: 1}, "pid"+sha[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob.CreatedAt = createdAt
+		if err := database.AddBlob(blob); err != nil {
+			t.Fatal(err)
+		}
+		return blob
+	}
+	fresh := add("aa00", time.Now())
+	old := add("bb00", time.Now().Add(-2*time.Hour))
+	stray := BlobKey("cc00", "jpg")
+	os.MkdirAll(filepath.Dir(store.Path(stray)), 0755)
+	os.WriteFile(store.Path(stray), nil, 0644)
+
+	report, err := dedup.GC(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Blobs) != 1 || report.Blobs[0].SHA256 != old.SHA256 || len(report.Stray) != 0 {
+		t.Errorf("GC = %+v, want only the old blob", report)
+	}
+	if ok, _ := store.Exists(fresh.Key); !ok {
+		t.Errorf("fresh blob deleted")
+	}
+	if ok, _ := store.Exists(old.Key); ok {
+		t.Errorf("old blob kept")
+	}
+
+	// 超过Grace后删除
+	past := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(store.Path(stray), past, past)
+	dedup.Grace = time.Minute
+	fresh.CreatedAt = past
+	database.AddBlob(fresh)
+	if report, err = dedup.GC(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Blobs) != 1 || len(report.Stray) != 1 || report.Stray[0] != stray {
+		t.Errorf("GC = %+v, want the fresh blob and the stray file", report)
+	}
+}
+
+func TestDedupLock(t *testing.T) {
+	dedup := &DedupStore{}
+	var mu sync.Mutex
+	running, max := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer dedup.lock("pid")()
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if max != 1 {
+		t.Errorf("%d holders of the same pid at once", max)
+	}
+	if n := dedupLocks.len(); n != 0 {
+		t.Errorf("%d locks left after unlock", n)
+	}
+}
+
+// 内容相同的不同pid共用一个blob，都能查到
+func TestDedupSharedPid(t *testing.T) {
+	database := newTestDatabase(t)
+	store := &LocalStore{Root: t.TempDir()}
+	dedup := &DedupStore{Store: store, Database: database, Grace: time.Nanosecond}
+
+	var first *MediaBlob
+	for _, pid := range []string{"pid1", "pid2"} {
+		file := filepath.Join(t.TempDir(), pid+".jpg")
+		os.WriteFile(file, []byte("same"), 0644)
+		blob, err := dedup.Add(file, &FetchResult{File: file, SHA256: "aa00", Size: 4}, pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = blob
+		}
+		if blob.Key != first.Key {
+			t.Errorf("Add(%s) = %s, want the existing blob %s", pid, blob.Key, first.Key)
+		}
+	}
+	for _, pid := range []string{"pid1", "pid2"} {
+		if blob, err := dedup.Lookup(pid); err != nil || blob == nil || blob.SHA256 != "aa00" {
+			t.Errorf("Lookup(%s) = %v, %v", pid, blob, err)
+		}
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := dedup.GC(false); err != nil {
+		t.Fatal(err)
+	}
+	if blob, err := dedup.Lookup("pid2"); err != nil || blob != nil {
+		t.Errorf("Lookup after GC = %v, %v, want nil", blob, err)
+	}
+	if pids, err := database.BlobPids("aa00"); err != nil || len(pids) != 0 {
+		t.Errorf("BlobPids after GC = %v, %v", pids, err)
+	}
+}
+
+// 迁移9按media_blob已有的pid补全media_pid
+func TestMigrateMediaPid(t *testing.T) {
+	database := &Database{DN: "sqlite", DSN: filepath.Join(t.TempDir(), "weibo.db")}
+	defer database.Close()
+	if err := database.MigrateTo(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AddBlob(&MediaBlob{SHA256: "aa00", Pid: "pid1", Ext: "jpg", Key: BlobKey("aa00", "jpg")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if blob, err := database.BlobByPid("pid1"); err != nil || blob == nil || blob.SHA256 != "aa00" {
+		t.Errorf("BlobByPid = %v, %v", blob, err)
+	}
+}
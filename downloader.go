@@ -0,0 +1,387 @@
+package weibo
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobPic    = "pic"    // 图片，按实际类型保存
+	JobMotion = "motion" // 实况照片的动态视频
+	JobVideo  = "video"  // 视频文件
+	JobCover  = "cover"  // 视频封面，按实际类型保存
+)
+
+// DownloadJob 单个待下载文件，Name为不含扩展名的文件名，通常为pid
+type DownloadJob struct {
+	Kind string
+	Name string
+	URL  string
+	Type string     // pic_infos中的type
+	Path string     // 未设置Downloader.Store时保存到的目录
+	Key  *MediaKey  // 用于生成存储路径，为空时只使用Kind和Name
+	Meta *MediaMeta // 图片的来源信息，用于json文件和XMP
+}
+
+func (job *DownloadJob) key() *MediaKey {
+	if job.Key != nil {
+		key := *job.Key
+		key.Kind, key.Name = job.Kind, job.Name
+		return &key
+	}
+	return &MediaKey{Kind: job.Kind, Name: job.Name}
+}
+
+type DownloadResult struct {
+	Job      *DownloadJob
+	File     string // 保存的文件名，使用Store时为key
+	Size     int64
+	SHA256   string
+	Skipped  bool // 文件已存在
+	Attempts int
+	Duration time.Duration
+	Err      error
+}
+
+// DownloadReport 每个文件的下载结果，顺序与提交的任务一致
+type DownloadReport struct {
+	Results []*DownloadResult
+}
+
+func (r *DownloadReport) Failed() []*DownloadResult {
+	var failed []*DownloadResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err 汇总所有失败的下载，全部成功时返回nil
+func (r *DownloadReport) Err() error {
+	var errs []error
+	for _, result := range r.Failed() {
+		errs = append(errs, fmt.Errorf("%s %s: %w", result.Job.Kind, result.Job.Name, result.Err))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *DownloadReport) String() string {
+	var ok, skipped, failed int
+	for _, result := range r.Results {
+		switch {
+		case result.Err != nil:
+			failed++
+		case result.Skipped:
+			skipped++
+		default:
+			ok++
+		}
+	}
+	return fmt.Sprintf("%d downloaded, %d skipped, %d failed", ok, skipped, failed)
+}
+
+// Downloader 并发下载媒体文件，限制总并发数和单个域名的并发数，失败后重试；
+// 文件先下载到Staging目录，校验完成后存入Store
+type Downloader struct {
+	Client  *Client
+	Store   MediaStore    // 为空时按DefaultLayout保存到任务的Path
+	Staging string        // 下载中的临时目录，默认为本地存储的根目录或系统临时目录
+	Dedup   *DedupStore   // 设置后按pid和sha256去重，文件存入Dedup.Store
+	Sidecar bool          // 图片旁保存来源信息的json文件
+	XMP     bool          // 将来源信息写入jpeg的XMP，去重时不写入以免改变内容
+	Workers int           // 总并发数，默认4
+	PerHost int           // 单个域名的并发数，默认2
+	Retries int           // 失败后的重试次数，默认2，小于0时不重试
+	Backoff time.Duration // 第n次重试前等待n*Backoff，默认1秒
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func (d *Downloader) host(fileUrl string) chan struct{} {
+	host := ""
+	if u, err := url.Parse(fileUrl); err == nil {
+		host = u.Host
+	}
+	perHost := d.PerHost
+	if perHost <= 0 {
+		perHost = 2
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.hosts == nil {
+		d.hosts = make(map[string]chan struct{})
+	}
+	sem, ok := d.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, perHost)
+		d.hosts[host] = sem
+	}
+	return sem
+}
+
+// Download 下载全部任务，单个任务失败不影响其他任务
+func (d *Downloader) Download(jobs []*DownloadJob) *DownloadReport {
+	workers := d.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+
+	report := &DownloadReport{Results: make([]*DownloadResult, len(jobs))}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				report.Results[i] = d.download(jobs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return report
+}
+
+func (d *Downloader) download(job *DownloadJob) *DownloadResult {
+	result := &DownloadResult{Job: job}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	if d.Dedup != nil {
+		return d.dedup(job, result)
+	}
+
+	store := d.store(job)
+	if key, ok, err := findKey(store, job.key(), jobExts(job)); err != nil {
+		result.Err = err
+		return result
+	} else if ok {
+		result.File, result.Skipped = key, true
+		result.Err = d.sidecar(store, job, key, true)
+		return result
+	}
+
+	fetched := d.retry(job, d.staging(store), result)
+	if result.Err != nil {
+		return result
+	}
+	if d.XMP && job.Kind == JobPic && job.Meta != nil {
+		if err := EmbedXMP(fetched.File, job.Meta); err != nil {
+			result.Err = err
+			return result
+		}
+		// 写入XMP后内容变化，重新计算
+		if fetched, result.Err = fileResult(fetched.File); result.Err != nil {
+			return result
+		}
+	}
+	key := job.key()
+	key.Ext = strings.TrimPrefix(filepath.Ext(fetched.File), ".")
+	result.File, result.Size, result.SHA256 = store.Key(key), fetched.Size, fetched.SHA256
+	if result.Err = store.Put(result.File, fetched.File); result.Err != nil {
+		return result
+	}
+	result.Err = d.sidecar(store, job, result.File, false)
+	return result
+}
+
+// sidecar 保存图片的json文件，existed时已存在的json文件不覆盖
+func (d *Downloader) sidecar(store MediaStore, job *DownloadJob, key string, existed bool) error {
+	if !d.Sidecar || job.Kind != JobPic || job.Meta == nil {
+		return nil
+	}
+	if existed {
+		if ok, err := store.Exists(SidecarKey(key)); err != nil || ok {
+			return err
+		}
+	}
+	return PutSidecar(store, key, job.Meta, d.staging(store))
+}
+
+// retry 下载到staging，临时错误按Backoff递增间隔重试，错误记录在result中
+func (d *Downloader) retry(job *DownloadJob, staging string, result *DownloadResult) *FetchResult {
+	retries := d.Retries
+	if retries == 0 {
+		retries = 2
+	} else if retries < 0 {
+		retries = 0
+	}
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	sem := d.host(job.URL)
+	for result.Attempts = 1; ; result.Attempts++ {
+		sem <- struct{}{}
+		var fetched *FetchResult
+		fetched, result.Err = d.fetch(job, staging)
+		<-sem
+		if result.Err == nil {
+			return fetched
+		}
+		var statusErr *StatusError
+		if result.Attempts > retries || errors.As(result.Err, &statusErr) && !statusErr.Temporary() {
+			return nil
+		}
+		time.Sleep(time.Duration(result.Attempts) * backoff)
+	}
+}
+
+// dedup 已有相同pid的文件时只记录引用，否则下载后按sha256去重
+func (d *Downloader) dedup(job *DownloadJob, result *DownloadResult) *DownloadResult {
+	key := job.key()
+	ref := &MediaRef{ID: key.ID, Kind: job.Kind, Idx: key.Idx}
+	pid := job.Name
+	if job.Kind != JobPic {
+		// 实况照片的视频和图片共用pid
+		pid = job.Name + "." + job.Kind
+	}
+	// 查询到记录引用之间不能有其他下载写入同一个pid
+	defer d.Dedup.lock(pid)()
+
+	blob, err := d.Dedup.Lookup(pid)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if blob != nil {
+		key.Ext = blob.Ext
+		ref.Key = d.Dedup.Store.Key(key)
+		result.File, result.Size, result.SHA256, result.Skipped = ref.Key, blob.Size, blob.SHA256, true
+		if result.Err = d.Dedup.Link(blob, ref); result.Err != nil {
+			return result
+		}
+		result.Err = d.sidecar(d.Dedup.Store, job, ref.Key, true)
+		return result
+	}
+
+	fetched := d.retry(job, d.staging(d.Dedup.Store), result)
+	if result.Err != nil {
+		return result
+	}
+	if blob, err = d.Dedup.Add(fetched.File, fetched, pid); err != nil {
+		result.Err = err
+		return result
+	}
+	key.Ext = blob.Ext
+	ref.Key = d.Dedup.Store.Key(key)
+	result.File, result.Size, result.SHA256 = ref.Key, fetched.Size, fetched.SHA256
+	if result.Err = d.Dedup.Link(blob, ref); result.Err != nil {
+		return result
+	}
+	result.Err = d.sidecar(d.Dedup.Store, job, ref.Key, false)
+	return result
+}
+
+func (d *Downloader) store(job *DownloadJob) MediaStore {
+	if d.Store != nil {
+		return d.Store
+	}
+	return &LocalStore{Root: job.Path}
+}
+
+func (d *Downloader) staging(store MediaStore) string {
+	if d.Staging != "" {
+		return d.Staging
+	}
+	if local, ok := store.(*LocalStore); ok {
+		return local.Root
+	}
+	return filepath.Join(os.TempDir(), "weibo-staging")
+}
+
+// jobExts 返回任务可能的扩展名，图片的类型在下载后才能确定
+func jobExts(job *DownloadJob) []string {
+	switch job.Kind {
+	case JobMotion:
+		return []string{".mov"}
+	case JobVideo:
+		return []string{".mp4"}
+	}
+	// 图片和视频封面
+	return picExts
+}
+
+// fetch 下载到staging目录，文件名带上Kind以免实况照片的图片和视频冲突
+func (d *Downloader) fetch(job *DownloadJob, staging string) (*FetchResult, error) {
+	name := filepath.Join(staging, job.Name+"."+job.Kind)
+	switch job.Kind {
+	case JobPic, JobCover:
+		return d.Client.fetch(job.URL, name, &FetchOptions{Kind: "image", PicType: job.Type})
+	case JobMotion:
+		return d.Client.fetch(job.URL, name, &FetchOptions{Kind: "video", Ext: ".mov"})
+	case JobVideo:
+		return d.Client.fetch(job.URL, name, &FetchOptions{Kind: "video", Ext: ".mp4"})
+	}
+	return nil, fmt.Errorf("unknown download job kind %q", job.Kind)
+}
+
+// PicJobs 返回博文及其转发博文的图片下载任务，实况照片包括动态视频
+func PicJobs(mblog *Mblog, path string) ([]*DownloadJob, error) {
+	var jobs []*DownloadJob
+	var errs []error
+	for _, m := range []*Mblog{mblog.Retweeted, mblog} {
+		if m == nil {
+			continue
+		}
+		post := m.Post()
+		pics, err := m.Pictures()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for i, pic := range pics {
+			picUrl, err := pic.Best()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			key := &MediaKey{UID: post.UID(), ID: post.ID, MblogID: post.MblogID, CreatedAt: post.CreatedAt, Idx: i}
+			meta := NewMediaMeta(post, i, pic.PicID, picUrl.URL)
+			jobs = append(jobs, &DownloadJob{Kind: JobPic, Name: pic.PicID, URL: picUrl.URL, Type: pic.Type, Path: path, Key: key, Meta: meta})
+			if pic.IsLivePhoto() {
+				jobs = append(jobs, &DownloadJob{Kind: JobMotion, Name: pic.PicID, URL: pic.Video, Path: path, Key: key})
+			}
+		}
+	}
+	return jobs, errors.Join(errs...)
+}
+
+// VideoJobs 返回博文视频及其封面的下载任务，均选择最高清晰度
+func VideoJobs(post *Post, path string) ([]*DownloadJob, error) {
+	var jobs []*DownloadJob
+	var errs []error
+	for i, video := range post.Videos {
+		key := &MediaKey{UID: post.UID(), ID: post.ID, MblogID: post.MblogID, CreatedAt: post.CreatedAt, Idx: i}
+		quality, err := video.Best()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		jobs = append(jobs, &DownloadJob{Kind: JobVideo, Name: video.ID, URL: quality.URL, Path: path, Key: key})
+		if video.Cover != "" {
+			jobs = append(jobs, &DownloadJob{Kind: JobCover, Name: video.ID, URL: video.Cover, Path: path, Key: key})
+		}
+	}
+	return jobs, errors.Join(errs...)
+}
+
+// DownPics 并发下载博文及其转发博文的图片
+func (d *Downloader) DownPics(mblog *Mblog, path string) (*DownloadReport, error) {
+	jobs, err := PicJobs(mblog, path)
+	return d.Download(jobs), err
+}
@@ -0,0 +1,93 @@
+package weibo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadXMPHash(t *testing.T) {
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 'J', 'F', 0xFF, 0xD9}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(jpeg)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := &Downloader{Client: &Client{}, XMP: true, Retries: -1}
+	post := &Post{ID: 1, MblogID: "abc", Text: "text", Author: &User{ID: 2}}
+	job := &DownloadJob{Kind: JobPic, Name: "pid", URL: srv.URL + "/pid.jpg", Path: dir, Meta: NewMediaMeta(post, 0, "pid", srv.URL+"/pid.jpg")}
+	report := d.Download([]*DownloadJob{job})
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := report.Results[0]
+	data, err := os.ReadFile(filepath.Join(dir, result.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, jpeg) {
+		t.Fatal("XMP not embedded")
+	}
+	sum := sha256.Sum256(data)
+	if result.SHA256 != hex.EncodeToString(sum[:]) || result.Size != int64(len(data)) {
+		t.Errorf("result = %s %d, want the hash and size of the file with XMP", result.SHA256, result.Size)
+	}
+	if n := partLocks.len(); n != 0 {
+		t.Errorf("%d part locks left after the download", n)
+	}
+}
+
+// 实况照片记录实际保存的key，重新保存博文时保留
+func TestLivePhotoKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/motion" {
+			w.Header().Set("Content-Type", "video/quicktime")
+			w.Write([]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  "))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer srv.Close()
+
+	d := &Downloader{Client: &Client{}, Store: &LocalStore{Root: t.TempDir(), Layout: "{uid}/{mblogid}_{idx}_{kind}.{ext}"}, Retries: -1}
+	key := &MediaKey{UID: 2, ID: 1, MblogID: "abc"}
+	report := d.Download([]*DownloadJob{
+		{Kind: JobPic, Name: "pid", URL: srv.URL + "/still", Key: key},
+		{Kind: JobMotion, Name: "pid", URL: srv.URL + "/motion", Key: key},
+	})
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	livePhotos := report.LivePhotos()
+	if len(livePhotos) != 1 || livePhotos[0].Still != "2/abc_0_pic.png" || livePhotos[0].Motion != "2/abc_0_motion.mov" {
+		t.Fatalf("LivePhotos() = %+v", livePhotos)
+	}
+
+	database := newTestDatabase(t)
+	post := &Post{ID: 1, MblogID: "abc", Author: &User{ID: 2}, Pictures: []*PostMedia{{ID: "pid", Type: "livephoto", VideoURL: srv.URL + "/motion"}}}
+	if err := database.AddPost(post); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AddLivePhotos(livePhotos); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AddPost(post); err != nil {
+		t.Fatal(err)
+	}
+	got, err := database.LivePhotos(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || *got[0] != *livePhotos[0] {
+		t.Errorf("LivePhotos = %+v, want %+v", got, livePhotos)
+	}
+}
@@ -0,0 +1,242 @@
+package weibo
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	EntityHashtag    = "hashtag"
+	EntityMention    = "mention"
+	EntityURL        = "url"
+	EntitySuperTopic = "supertopic"
+)
+
+var (
+	hashtagRe = regexp.MustCompile(`#([^#\n]+?)#`)
+	mentionRe = regexp.MustCompile(`@([\p{Han}\p{L}\p{N}_\-]{1,30})`)
+	urlRe     = regexp.MustCompile(`https?://[A-Za-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]+`)
+)
+
+// Entity 博文中的话题、@用户、链接和超话，Start和End为字符（rune）偏移，左闭右开
+type Entity struct {
+	Type    string `json:"type"`
+	Text    string `json:"text"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Name    string `json:"name,omitempty"` // 话题名、超话名或昵称
+	UID     int64  `json:"uid,omitempty"`  // @用户的uid
+	URL     string `json:"url,omitempty"`
+	LongURL string `json:"long_url,omitempty"` // t.cn短链接的原始地址
+	Title   string `json:"title,omitempty"`
+}
+
+type TopicStruct struct {
+	Title      string `json:"title"`
+	TopicUrl   string `json:"topic_url"`
+	TopicTitle string `json:"topic_title"`
+	IsInvalid  int    `json:"is_invalid"`
+}
+
+// isSuperTopic 超话的page_id以100808开头
+func (u *UrlStruct) isSuperTopic() bool {
+	return strings.HasPrefix(u.PageID, "100808") || strings.Contains(u.LongUrl, "100808")
+}
+
+// ExtractEntities 从纯文本中提取实体，urls用于将短链接映射为原始地址并识别超话，
+// users用于补全@用户的uid
+func ExtractEntities(text string, urls []UrlStruct, users ...*User) []*Entity {
+	var entities []*Entity
+	offset := func(i int) int {
+		return utf8.RuneCountInString(text[:i])
+	}
+	names := make(map[string]int64)
+	for _, user := range users {
+		if user != nil && user.Name != "" {
+			names[user.Name] = user.ID
+		}
+	}
+
+	for _, m := range hashtagRe.FindAllStringSubmatchIndex(text, -1) {
+		name := text[m[2]:m[3]]
+		entity := &Entity{Type: EntityHashtag, Text: text[m[0]:m[1]], Start: offset(m[0]), End: offset(m[1]), Name: name}
+		if strings.HasSuffix(name, "[超话]") {
+			entity.Type = EntitySuperTopic
+			entity.Name = strings.TrimSuffix(name, "[超话]")
+		}
+		entities = append(entities, entity)
+	}
+	for _, m := range mentionRe.FindAllStringSubmatchIndex(text, -1) {
+		name := text[m[2]:m[3]]
+		entities = append(entities, &Entity{Type: EntityMention, Text: text[m[0]:m[1]], Start: offset(m[0]), End: offset(m[1]), Name: name, UID: names[name]})
+	}
+
+	byShort := make(map[string]UrlStruct)
+	for _, u := range urls {
+		byShort[u.ShortUrl] = u
+		if u.OriUrl != "" {
+			byShort[u.OriUrl] = u
+		}
+	}
+	for _, m := range urlRe.FindAllStringIndex(text, -1) {
+		link := text[m[0]:m[1]]
+		entity := &Entity{Type: EntityURL, Text: link, Start: offset(m[0]), End: offset(m[1]), URL: link}
+		if u, ok := byShort[link]; ok {
+			entity.LongURL = u.LongUrl
+			entity.Title = u.UrlTitle
+			if u.isSuperTopic() {
+				entity.Type = EntitySuperTopic
+				entity.Name = strings.TrimSuffix(u.UrlTitle, "超话")
+			}
+		}
+		entities = append(entities, entity)
+	}
+
+	// 文本中以标题形式出现的超话，例如"xxx超话"
+	for _, u := range urls {
+		if !u.isSuperTopic() || u.UrlTitle == "" || strings.Contains(text, u.ShortUrl) && u.ShortUrl != "" {
+			continue
+		}
+		if i := strings.Index(text, u.UrlTitle); i >= 0 {
+			entities = append(entities, &Entity{Type: EntitySuperTopic, Text: u.UrlTitle, Start: offset(i), End: offset(i + len(u.UrlTitle)),
+				Name: strings.TrimSuffix(u.UrlTitle, "超话"), URL: u.ShortUrl, LongURL: u.LongUrl, Title: u.UrlTitle})
+		}
+	}
+
+	sortEntities(entities)
+	return entities
+}
+
+func sortEntities(entities []*Entity) {
+	for i := 1; i < len(entities); i++ {
+		for j := i; j > 0 && entities[j].Start < entities[j-1].Start; j-- {
+			entities[j], entities[j-1] = entities[j-1], entities[j]
+		}
+	}
+}
+
+// Entities 提取博文中的实体
+func (m *Mblog) Entities() []*Entity {
+	users := []*User{m.User}
+	for r := m.Retweeted; r != nil; r = r.Retweeted {
+		users = append(users, r.User)
+	}
+	return withTopics(ExtractEntities(m.TheText(), m.UrlStruct, users...), m.TopicStruct)
+}
+
+// withTopics 用topic_struct补全话题的链接和标题，超话话题转为超话，
+// 失效的话题微博不加链接，不作为实体
+func withTopics(entities []*Entity, topics []*TopicStruct) []*Entity {
+	byName := make(map[string]*TopicStruct)
+	for _, topic := range topics {
+		if topic != nil && topic.TopicTitle != "" {
+			byName[topic.TopicTitle] = topic
+		}
+	}
+	if len(byName) == 0 {
+		return entities
+	}
+	result := entities[:0]
+	for _, entity := range entities {
+		topic, ok := byName[entity.Name]
+		if entity.Type != EntityHashtag || !ok {
+			result = append(result, entity)
+			continue
+		}
+		if topic.IsInvalid == 1 {
+			continue
+		}
+		entity.URL = topic.TopicUrl
+		if topic.Title != "" {
+			entity.Title = topic.Title
+		}
+		if id := topicContainerID(topic.TopicUrl); strings.HasPrefix(id, "100808") {
+			entity.Type = EntitySuperTopic
+			entity.LongURL = "https://weibo.com/p/" + id + "/super_index"
+		}
+		result = append(result, entity)
+	}
+	return result
+}
+
+// topicContainerID 返回话题链接中的containerid
+func topicContainerID(topicUrl string) string {
+	u, err := url.Parse(topicUrl)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("containerid")
+}
+
+// Entities 提取手机端博文中的实体，手机端没有url_struct，
+// 未获取长文本时链接取自HTML中的<a>
+func (m *CMblog) Entities() []*Entity {
+	users := []*User{m.User}
+	for r := m.Retweeted; r != nil; r = r.Retweeted {
+		users = append(users, r.User)
+	}
+	if m.LongTextRaw != "" {
+		return ExtractEntities(m.LongTextRaw, nil, users...)
+	}
+
+	text, anchors := (&Renderer{}).renderHTML(m.Text)
+	var entities []*Entity
+	for _, entity := range ExtractEntities(text, nil, users...) {
+		// 纯文本中链接后附的地址由对应的<a>给出
+		if entity.Type == EntityURL && insideAnchor(entity, anchors) {
+			continue
+		}
+		entities = append(entities, entity)
+	}
+	runes := []rune(text)
+	for _, a := range anchors {
+		if a.target == "" || strings.HasPrefix(a.text, "@") || strings.HasPrefix(a.text, "#") || a.end > len(runes) {
+			continue
+		}
+		entity := &Entity{Type: EntityURL, Text: string(runes[a.start:a.end]), Start: a.start, End: a.end,
+			URL: a.target, LongURL: a.target, Title: a.text}
+		if dataURL := safeURL(a.dataURL); dataURL != "" {
+			entity.URL = dataURL
+		}
+		if strings.Contains(a.target, "100808") {
+			entity.Type = EntitySuperTopic
+			entity.Name = strings.TrimSuffix(a.text, "超话")
+		}
+		entities = append(entities, entity)
+	}
+	sortEntities(entities)
+	return entities
+}
+
+func insideAnchor(entity *Entity, anchors []*anchor) bool {
+	for _, a := range anchors {
+		if entity.Start < a.rendered && entity.End > a.start {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveMentions 通过昵称查询补全@用户的uid，同一昵称只查询一次
+func (c *Client) ResolveMentions(entities []*Entity) error {
+	uids := make(map[string]int64)
+	for _, entity := range entities {
+		if entity.Type != EntityMention || entity.UID != 0 {
+			continue
+		}
+		uid, ok := uids[entity.Name]
+		if !ok {
+			ref, err := c.resolveName(entity.Name)
+			if err != nil {
+				return err
+			}
+			uid, _ = strconv.ParseInt(ref.UID, 10, 64)
+			uids[entity.Name] = uid
+		}
+		entity.UID = uid
+	}
+	return nil
+}
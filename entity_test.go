@@ -0,0 +1,57 @@
+package weibo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func formatEntities(entities []*Entity) string {
+	var got []string
+	for _, e := range entities {
+		got = append(got, fmt.Sprintf("%s:%s@%d-%d:%s:%s:%s", e.Type, e.Name, e.Start, e.End, e.URL, e.LongURL, e.Title))
+	}
+	return strings.Join(got, " ")
+}
+
+func TestMblogEntitiesTopicStruct(t *testing.T) {
+	mblog := &Mblog{
+		TextRaw: "#话题# #失效# #明星#",
+		TopicStruct: []*TopicStruct{
+			{Title: "话题标题", TopicTitle: "话题", TopicUrl: "sinaweibo://searchall?containerid=231522&q=%23话题%23"},
+			{TopicTitle: "失效", IsInvalid: 1},
+			{TopicTitle: "明星", TopicUrl: "sinaweibo://pageinfo?containerid=100808abc"},
+		},
+	}
+	want := "hashtag:话题@0-4:sinaweibo://searchall?containerid=231522&q=%23话题%23::话题标题 " +
+		"supertopic:明星@10-14:sinaweibo://pageinfo?containerid=100808abc:https://weibo.com/p/100808abc/super_index:"
+	if got := formatEntities(mblog.Entities()); got != want {
+		t.Errorf("Entities() = %s\nwant %s", got, want)
+	}
+}
+
+func TestCMblogEntitiesFromHTML(t *testing.T) {
+	mblog := &CMblog{Text: `看 <a href="https://weibo.cn/sinaurl?u=https%3A%2F%2Fexample.com%2Fa" data-url="http://t.cn/A1">网页链接</a> ` +
+		`<a href="/p/100808abc">明星超话</a> <a href="/n/小明">@小明</a> <a href="/search?q=%23话题%23">#话题#</a>`}
+	want := "url:@2-6:http://t.cn/A1:https://example.com/a:网页链接 " +
+		"supertopic:明星@31-35:https://weibo.com/p/100808abc:https://weibo.com/p/100808abc:明星超话 " +
+		"mention:小明@68-71::: hashtag:话题@72-76:::"
+	if got := formatEntities(mblog.Entities()); got != want {
+		t.Errorf("Entities() = %s\nwant %s", got, want)
+	}
+}
+
+func TestEntitiesTitle(t *testing.T) {
+	database := newTestDatabase(t)
+	entities := []*Entity{{Type: EntityURL, Text: "http://t.cn/A1", End: 14, URL: "http://t.cn/A1", LongURL: "https://example.com", Title: "网页链接"}}
+	if err := database.AddEntities(1, entities); err != nil {
+		t.Fatal(err)
+	}
+	got, err := database.Entities(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatEntities(got) != formatEntities(entities) {
+		t.Errorf("Entities = %s, want %s", formatEntities(got), formatEntities(entities))
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"github.com/berbai/weibo"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	"github.com/robfig/cron/v3"
 	"github.com/urfave/cli/v2"
 	"log"
+	_ "modernc.org/sqlite"
 	"os"
 	"strings"
 	"time"
@@ -25,6 +28,16 @@ type App struct {
 	userid   string
 	page     int
 	sleep    int
+	cpdir    string
+	window   int
+	snapspec string
+	snapdays int
+	media    string
+	layout   string
+	mstore   weibo.MediaStore
+	dedup    bool
+	sidecar  bool
+	xmp      bool
 }
 
 func (app *App) Run() error {
@@ -45,7 +58,7 @@ func (app *App) Run() error {
 				Name:        "userid",
 				Aliases:     []string{"u"},
 				Value:       "1223178222",
-				Usage:       "userid list",
+				Usage:       "userid list, accepts uid, screen name or profile link",
 				Destination: &app.userid,
 				EnvVars:     []string{"WEIBO_COLLECTOR_USERID"},
 			},
@@ -69,17 +82,24 @@ func (app *App) Run() error {
 				Name:        "dn",
 				Aliases:     []string{"d"},
 				Value:       "mysql",
-				Usage:       "database driver name",
+				Usage:       "database driver name, mysql, sqlite or postgres",
 				Destination: &app.database.DN,
 				EnvVars:     []string{"WEIBO_COLLECTOR_DN"},
 			},
 			&cli.StringFlag{
 				Name:        "dsn",
-				Value:       "root:root@/weibo",
+				Value:       "root:root@/weibo?parseTime=true",
 				Usage:       "database source name",
 				Destination: &app.database.DSN,
 				EnvVars:     []string{"WEIBO_COLLECTOR_DSN"},
 			},
+			&cli.BoolFlag{
+				Name:        "raw-gzip",
+				Value:       false,
+				Usage:       "gzip the raw api json saved with each mblog",
+				Destination: &app.database.Gzip,
+				EnvVars:     []string{"WEIBO_COLLECTOR_RAW_GZIP"},
+			},
 			&cli.BoolFlag{
 				Name:        "full",
 				Aliases:     []string{"f"},
@@ -88,6 +108,20 @@ func (app *App) Run() error {
 				Destination: &app.full,
 				EnvVars:     []string{"WEIBO_COLLECTOR_FULl"},
 			},
+			&cli.StringFlag{
+				Name:        "checkpoint",
+				Value:       ".checkpoint",
+				Usage:       "full collect checkpoint directory",
+				Destination: &app.cpdir,
+				EnvVars:     []string{"WEIBO_COLLECTOR_CHECKPOINT"},
+			},
+			&cli.IntFlag{
+				Name:        "window",
+				Value:       30,
+				Usage:       "full collect window days",
+				Destination: &app.window,
+				EnvVars:     []string{"WEIBO_COLLECTOR_WINDOW"},
+			},
 			&cli.StringFlag{
 				Name:        "cron",
 				Value:       "*/1 * * * *",
@@ -95,6 +129,55 @@ func (app *App) Run() error {
 				Destination: &app.spec,
 				EnvVars:     []string{"WEIBO_COLLECTOR_SPEC"},
 			},
+			&cli.StringFlag{
+				Name:        "snapshot-cron",
+				Value:       "0 * * * *",
+				Usage:       "engagement snapshot cron spec, empty to disable",
+				Destination: &app.snapspec,
+				EnvVars:     []string{"WEIBO_COLLECTOR_SNAPSHOT_SPEC"},
+			},
+			&cli.IntFlag{
+				Name:        "snapshot-days",
+				Value:       7,
+				Usage:       "track engagement of mblogs within days",
+				Destination: &app.snapdays,
+				EnvVars:     []string{"WEIBO_COLLECTOR_SNAPSHOT_DAYS"},
+			},
+			&cli.StringFlag{
+				Name:        "media",
+				Value:       "",
+				Usage:       "download media to a directory or s3://bucket/prefix, empty to disable",
+				Destination: &app.media,
+				EnvVars:     []string{"WEIBO_COLLECTOR_MEDIA"},
+			},
+			&cli.StringFlag{
+				Name:        "media-layout",
+				Value:       weibo.DefaultLayout,
+				Usage:       "media path layout, e.g. {uid}/{yyyy}/{mm}/{mblogid}_{idx}.{ext}",
+				Destination: &app.layout,
+				EnvVars:     []string{"WEIBO_COLLECTOR_MEDIA_LAYOUT"},
+			},
+			&cli.BoolFlag{
+				Name:        "media-dedup",
+				Value:       false,
+				Usage:       "store media once by pid and sha256, linking each mblog to the shared file",
+				Destination: &app.dedup,
+				EnvVars:     []string{"WEIBO_COLLECTOR_MEDIA_DEDUP"},
+			},
+			&cli.BoolFlag{
+				Name:        "media-sidecar",
+				Value:       false,
+				Usage:       "save a json file with the mblog info next to each picture",
+				Destination: &app.sidecar,
+				EnvVars:     []string{"WEIBO_COLLECTOR_MEDIA_SIDECAR"},
+			},
+			&cli.BoolFlag{
+				Name:        "media-xmp",
+				Value:       false,
+				Usage:       "write author, date, text and link into jpeg xmp",
+				Destination: &app.xmp,
+				EnvVars:     []string{"WEIBO_COLLECTOR_MEDIA_XMP"},
+			},
 			&cli.StringFlag{
 				Name:        "tz",
 				Aliases:     []string{"t"},
@@ -105,18 +188,93 @@ func (app *App) Run() error {
 			},
 		},
 		Action: app.run,
+		Commands: []*cli.Command{
+			{
+				Name:  "gc",
+				Usage: "remove deduplicated media no stored mblog references",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only list what would be removed",
+					},
+					&cli.DurationFlag{
+						Name:  "grace",
+						Value: weibo.DefaultGrace,
+						Usage: "keep blobs written within this duration, they may still be downloading",
+					},
+				},
+				Action: app.gc,
+			},
+			{
+				Name:  "audit",
+				Usage: "check stored mblog pictures against the media store",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "repair",
+						Usage: "download missing or corrupt pictures again",
+					},
+				},
+				Action: app.audit,
+			},
+			{
+				Name:   "reprocess",
+				Usage:  "rebuild mblogs, comments and users from the saved raw api json",
+				Action: app.reprocess,
+			},
+			{
+				Name:   "migrate",
+				Usage:  "migrate the database schema to the latest version",
+				Action: app.migrate,
+				Subcommands: []*cli.Command{
+					{
+						Name:  "up",
+						Usage: "apply pending migrations",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "to",
+								Usage: "stop at this version, 0 for the latest",
+							},
+						},
+						Action: app.migrate,
+					},
+					{
+						Name:  "down",
+						Usage: "roll back applied migrations",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "steps",
+								Value: 1,
+								Usage: "number of migrations to roll back",
+							},
+						},
+						Action: app.rollback,
+					},
+					{
+						Name:   "status",
+						Usage:  "show applied and pending migrations",
+						Action: app.migrateStatus,
+					},
+				},
+			},
+		},
 	}
 	print(app.cli)
 	return app.cli.Run(os.Args)
 }
 
 func (app *App) run(c *cli.Context) error {
+	if err := app.resolve(); err != nil {
+		return err
+	}
+	if err := app.mediaStore(); err != nil {
+		return err
+	}
 	if err := app.database.Migrate(); err != nil {
 		return err
 	}
 	if app.full {
 		logger.Printf("full collecting.")
-		if _, err := app.collect(true); err != nil {
+		if err := app.backfill(); err != nil {
 			return err
 		}
 		logger.Printf("full collecting finished.")
@@ -124,6 +282,141 @@ func (app *App) run(c *cli.Context) error {
 	return app.cron()
 }
 
+func (app *App) resolve() error {
+	var userids []string
+	for _, userid := range strings.Split(app.userid, ",") {
+		uid, err := app.client.ResolveUID(userid)
+		if err != nil {
+			return err
+		}
+		userids = append(userids, uid)
+	}
+	app.userid = strings.Join(userids, ",")
+	return nil
+}
+
+// mediaStore s3的endpoint和密钥从环境变量读取
+func (app *App) mediaStore() error {
+	if app.media == "" {
+		return nil
+	}
+	if !strings.HasPrefix(app.media, "s3://") {
+		app.mstore = &weibo.LocalStore{Root: app.media, Layout: app.layout}
+		return nil
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(app.media, "s3://"), "/")
+	endpoint := os.Getenv("WEIBO_COLLECTOR_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	app.mstore = &weibo.S3Store{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("AWS_REGION"),
+		Bucket:    bucket,
+		Prefix:    prefix,
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Layout:    app.layout,
+	}
+	return nil
+}
+
+func (app *App) gc(c *cli.Context) error {
+	if err := app.mediaStore(); err != nil {
+		return err
+	}
+	if app.mstore == nil {
+		return fmt.Errorf("--media is required")
+	}
+	if err := app.database.Migrate(); err != nil {
+		return err
+	}
+	dedup := &weibo.DedupStore{Store: app.mstore, Database: app.database, Grace: c.Duration("grace")}
+	report, err := dedup.GC(c.Bool("dry-run"))
+	if err != nil {
+		return err
+	}
+	for _, ref := range report.Refs {
+		logger.Printf("gc ref. id=%d, key=%s", ref.ID, ref.Key)
+	}
+	for _, blob := range report.Blobs {
+		logger.Printf("gc blob. pid=%s, key=%s", blob.Pid, blob.Key)
+	}
+	for _, key := range report.Stray {
+		logger.Printf("gc stray. key=%s", key)
+	}
+	logger.Printf("gc finished. refs=%d, blobs=%d, stray=%d", len(report.Refs), len(report.Blobs), len(report.Stray))
+	return nil
+}
+
+func (app *App) audit(c *cli.Context) error {
+	if err := app.mediaStore(); err != nil {
+		return err
+	}
+	if app.mstore == nil {
+		return fmt.Errorf("--media is required")
+	}
+	if err := app.database.Migrate(); err != nil {
+		return err
+	}
+	auditor := &weibo.Auditor{
+		Database:   app.database,
+		Downloader: app.downloader(),
+		Repair:     c.Bool("repair"),
+		Progress: func(item *weibo.AuditItem) {
+			if item.Status != weibo.AuditOK {
+				logger.Printf("audit %s. mblogid=%s, idx=%d, key=%s, err='%v'", item.Status, item.Pic.MblogID, item.Pic.Idx, item.Key, item.Err)
+			}
+		},
+	}
+	report, err := auditor.Run()
+	if err != nil {
+		return err
+	}
+	logger.Printf("audit finished. %s", report)
+	return nil
+}
+
+func (app *App) reprocess(c *cli.Context) error {
+	if err := app.database.Migrate(); err != nil {
+		return err
+	}
+	n, err := app.database.Reprocess(func(payload *weibo.RawPayload) {
+		logger.Printf("reprocess %s. id=%d, endpoint=%s, fetched_at=%s", payload.Kind, payload.ID, payload.Endpoint, payload.FetchedAt.Format(time.DateTime))
+	})
+	if err != nil {
+		return err
+	}
+	logger.Printf("reprocess finished. count=%d", n)
+	return nil
+}
+
+func (app *App) migrate(c *cli.Context) error {
+	if to := c.Int("to"); to > 0 {
+		return app.database.MigrateTo(to)
+	}
+	return app.database.Migrate()
+}
+
+func (app *App) rollback(c *cli.Context) error {
+	return app.database.Rollback(c.Int("steps"))
+}
+
+func (app *App) migrateStatus(c *cli.Context) error {
+	status, err := app.database.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		if s.AppliedAt.IsZero() {
+			logger.Printf("migration %d %s pending", s.Version, s.Name)
+		} else {
+			logger.Printf("migration %d %s applied at %s", s.Version, s.Name, s.AppliedAt.Format(time.DateTime))
+		}
+	}
+	return nil
+}
+
 func (app *App) cron() error {
 	logger.Printf("monitoring.")
 	c := cron.New(
@@ -132,16 +425,62 @@ func (app *App) cron() error {
 		cron.WithChain(cron.SkipIfStillRunning(cron.VerbosePrintfLogger(logger))),
 	)
 	c.AddFunc(app.spec, app.monitoring)
+	if app.snapspec != "" {
+		c.AddFunc(app.snapspec, app.snapshot)
+	}
 	c.Run()
 
 	return nil
 }
 
-func (app *App) collect(full bool) ([]*weibo.Mblog, error) {
-	page := 1
-	if full {
-		page = 98
+func (app *App) backfill() error {
+	b := &weibo.Backfill{
+		Client:      app.client,
+		Checkpoints: &weibo.FileCheckpointStore{Dir: app.cpdir},
+		Window:      time.Duration(app.window) * 24 * time.Hour,
+		Sleep:       time.Duration(app.sleep) * time.Second,
+		Longtext:    true,
+		Handle:      app.store,
+		Progress: func(cp *weibo.Checkpoint) {
+			logger.Printf("full collecting. %s", cp)
+		},
 	}
+	for _, userid := range strings.Split(app.userid, ",") {
+		if err := b.Run(userid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) store(mblogs []*weibo.Mblog) error {
+	_, err := app.add(mblogs)
+	return err
+}
+
+// add 保存新的博文及其第一个互动数快照，返回新增的博文
+func (app *App) add(mblogs []*weibo.Mblog) ([]*weibo.Mblog, error) {
+	var added []*weibo.Mblog
+	for _, mblog := range mblogs {
+		if has, err := app.database.HasMblog(mblog); err != nil {
+			return nil, err
+		} else if has {
+			continue
+		}
+		if err := app.database.AddMblog(mblog); err != nil {
+			return nil, err
+		}
+		if err := app.database.AddSnapshot(weibo.NewSnapshot(mblog.Post(), time.Now())); err != nil {
+			return nil, err
+		}
+		app.download(mblog)
+		added = append(added, mblog)
+	}
+	return added, nil
+}
+
+func (app *App) collect() ([]*weibo.Mblog, error) {
+	page := 1
 
 	var mblogs []*weibo.Mblog
 	for _, userid := range strings.Split(app.userid, ",") {
@@ -152,18 +491,11 @@ func (app *App) collect(full bool) ([]*weibo.Mblog, error) {
 				return nil, err
 			}
 
-			for _, mblog := range _mblogs {
-				if has, err := app.database.HasMblog(mblog); err != nil {
-					return nil, err
-				} else if has {
-					continue
-				}
-
-				if err := app.database.AddMblog(mblog); err != nil {
-					return nil, err
-				}
-				mblogs = append(mblogs, mblog)
+			added, err := app.add(_mblogs)
+			if err != nil {
+				return nil, err
 			}
+			mblogs = append(mblogs, added...)
 			logger.Printf("sleep %d seconds", app.sleep)
 			time.Sleep(time.Duration(app.sleep) * time.Second)
 		}
@@ -171,8 +503,39 @@ func (app *App) collect(full bool) ([]*weibo.Mblog, error) {
 	return mblogs, nil
 }
 
+// download 下载博文的图片和视频，失败只记录日志，可以之后通过审计补齐
+func (app *App) download(mblog *weibo.Mblog) {
+	if app.mstore == nil {
+		return
+	}
+	jobs, err := weibo.PicJobs(mblog, "")
+	if err != nil {
+		logger.Printf("download %s, err='%s'\n", mblog.MblogID, err)
+	}
+	videoJobs, err := weibo.VideoJobs(mblog.Post(), "")
+	if err != nil {
+		logger.Printf("download %s, err='%s'\n", mblog.MblogID, err)
+	}
+	report := app.downloader().Download(append(jobs, videoJobs...))
+	logger.Printf("download %s, %s", mblog.MblogID, report)
+	if err := app.database.AddMedia(report.Media()); err != nil {
+		logger.Printf("download %s, err='%s'\n", mblog.MblogID, err)
+	}
+	if err := report.Err(); err != nil {
+		logger.Printf("download %s, err='%s'\n", mblog.MblogID, err)
+	}
+}
+
+func (app *App) downloader() *weibo.Downloader {
+	downloader := &weibo.Downloader{Client: app.client, Store: app.mstore, Sidecar: app.sidecar, XMP: app.xmp}
+	if app.dedup {
+		downloader.Dedup = &weibo.DedupStore{Store: app.mstore, Database: app.database}
+	}
+	return downloader
+}
+
 func (app *App) monitoring() {
-	if mblogs, err := app.collect(false); err != nil {
+	if mblogs, err := app.collect(); err != nil {
 		logger.Printf("monitoring, err='%s'\n", err)
 	} else {
 		if len(mblogs) > 0 {
@@ -182,6 +545,19 @@ func (app *App) monitoring() {
 	}
 }
 
+func (app *App) snapshot() {
+	tracker := &weibo.Tracker{
+		Client:   app.client,
+		Database: app.database,
+		Recent:   time.Duration(app.snapdays) * 24 * time.Hour,
+	}
+	n, err := tracker.Poll()
+	if err != nil {
+		logger.Printf("snapshot, err='%s'\n", err)
+	}
+	logger.Printf("snapshot %d mblogs.", n)
+}
+
 func (app *App) notification(mblogs []*weibo.Mblog) {
 	logger.Printf("send notification.")
 }
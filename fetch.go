@@ -0,0 +1,307 @@
+package weibo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StatusError 下载时服务器返回了非200/206的状态码
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("download %s: status %d", e.URL, e.Code)
+}
+
+// Temporary 5xx和429可以重试，其余4xx（例如CDN地址过期）重试无意义
+func (e *StatusError) Temporary() bool {
+	return e.Code >= 500 || e.Code == http.StatusTooManyRequests
+}
+
+var InvalidMedia = errors.New("InvalidMedia")
+
+// FetchOptions 下载选项，零值表示不校验
+type FetchOptions struct {
+	Kind     string // image或video，用于校验Content-Type和文件头
+	PicType  string // pic_infos中的type，辅助判断扩展名
+	Ext      string // 指定扩展名，为空时根据内容判断
+	Size     int64  // 期望的文件大小
+	SHA256   string // 期望的sha256，十六进制
+	Progress func(written, total int64)
+}
+
+// FetchResult 下载完成的文件
+type FetchResult struct {
+	File   string
+	Size   int64
+	SHA256 string
+}
+
+// partLocks 按.part文件名加锁，同一个.part同时只有一个下载在写
+var partLocks keyedMutex
+
+// keyedMutex 按key加锁，key没有持有或等待者时删除，避免下载的文件越多占用越多
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+// lock 锁定key，返回解锁的函数
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*refMutex)
+	}
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		if m.refs--; m.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// len 返回当前持有或等待中的key数
+func (k *keyedMutex) len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.locks)
+}
+
+// partName 返回下载中的临时文件名，带上地址的hash，不同地址的内容不会续传到同一个文件
+func partName(name string, fileUrl string) string {
+	sum := sha256.Sum256([]byte(fileUrl))
+	return name + "." + hex.EncodeToString(sum[:4]) + ".part"
+}
+
+// fetch 流式下载fileUrl到name+扩展名：先写入.part，同一地址已有的.part通过Range续传，
+// 校验状态码、Content-Type、文件头、大小和sha256后原子重命名
+func (c *Client) fetch(fileUrl string, name string, opts *FetchOptions) (*FetchResult, error) {
+	if opts == nil {
+		opts = &FetchOptions{}
+	}
+	if err := mkdir(dirOf(name)); err != nil {
+		return nil, err
+	}
+	part := partName(name, fileUrl)
+	defer partLocks.lock(part)()
+
+	client := c.httpClient()
+
+	req, err := http.NewRequest("GET", fileUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:107.0) Gecko/20100101 Firefox/107.0")
+	req.Header.Set("Cookie", c.Cookie)
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("referer", "https://weibo.com/")
+
+	var offset int64
+	if info, err := os.Stat(part); err == nil && info.Size() > 0 {
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var total int64 = -1
+	flags := os.O_CREATE | os.O_WRONLY
+	switch res.StatusCode {
+	case http.StatusOK:
+		offset = 0
+		flags |= os.O_TRUNC
+		total = res.ContentLength
+	case http.StatusPartialContent:
+		if start, size, ok := parseContentRange(res.Header.Get("Content-Range")); !ok || start != offset {
+			return nil, fmt.Errorf("download %s: unexpected Content-Range %q", fileUrl, res.Header.Get("Content-Range"))
+		} else {
+			total = size
+		}
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// .part已损坏或服务器文件已变化，下次从头下载
+		os.Remove(part)
+		return nil, &StatusError{URL: fileUrl, Code: res.StatusCode}
+	default:
+		return nil, &StatusError{URL: fileUrl, Code: res.StatusCode}
+	}
+	if err := checkContentType(res.Header.Get("Content-Type"), opts.Kind); err != nil {
+		return nil, fmt.Errorf("download %s: %w", fileUrl, err)
+	}
+
+	h := sha256.New()
+	if offset > 0 {
+		if err := hashFile(h, part); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &progressWriter{written: offset, total: total, progress: opts.Progress}
+	written, err := io.Copy(io.MultiWriter(f, h, w), res.Body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// 保留.part以便续传
+		return nil, err
+	}
+
+	size := offset + written
+	sum := hex.EncodeToString(h.Sum(nil))
+	if err := verify(part, size, total, sum, opts); err != nil {
+		os.Remove(part)
+		return nil, fmt.Errorf("download %s: %w", fileUrl, err)
+	}
+
+	ext := opts.Ext
+	if ext == "" {
+		head, err := readHead(part, 512)
+		if err != nil {
+			return nil, err
+		}
+		ext = DetectExt(head, res.Header.Get("Content-Type"), opts.PicType, fileUrl)
+	}
+	if err := os.Rename(part, name+ext); err != nil {
+		return nil, err
+	}
+	return &FetchResult{File: name + ext, Size: size, SHA256: sum}, nil
+}
+
+func verify(part string, size, total int64, sum string, opts *FetchOptions) error {
+	if total >= 0 && size != total {
+		return fmt.Errorf("truncated, got %d of %d bytes: %w", size, total, InvalidMedia)
+	}
+	if opts.Size > 0 && size != opts.Size {
+		return fmt.Errorf("size %d, expected %d: %w", size, opts.Size, InvalidMedia)
+	}
+	if opts.SHA256 != "" && !strings.EqualFold(sum, opts.SHA256) {
+		return fmt.Errorf("sha256 %s, expected %s: %w", sum, opts.SHA256, InvalidMedia)
+	}
+	if opts.Kind == "image" {
+		head, err := readHead(part, 16)
+		if err != nil {
+			return err
+		}
+		if ext := sniffExt(head); ext == "" || ext == ".mp4" || ext == ".mov" {
+			return fmt.Errorf("not an image: %w", InvalidMedia)
+		}
+	}
+	return nil
+}
+
+// checkContentType 拒绝错误页面之类的响应
+func checkContentType(contentType string, kind string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", mediaType == "application/xml":
+		return fmt.Errorf("unexpected Content-Type %s: %w", mediaType, InvalidMedia)
+	case kind == "image" && strings.HasPrefix(mediaType, "video/"):
+		return fmt.Errorf("unexpected Content-Type %s: %w", mediaType, InvalidMedia)
+	}
+	return nil
+}
+
+// parseContentRange 解析"bytes 100-199/200"
+func parseContentRange(s string) (start, size int64, ok bool) {
+	s = strings.TrimPrefix(s, "bytes ")
+	rng, total, found := strings.Cut(s, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, size, true
+}
+
+// fileResult 重新计算文件的大小和sha256
+func fileResult(name string) (*FetchResult, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if err := hashFile(h, name); err != nil {
+		return nil, err
+	}
+	return &FetchResult{File: name, Size: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func hashFile(h hash.Hash, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+func readHead(name string, n int) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, n)
+	m, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:m], nil
+}
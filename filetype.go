@@ -0,0 +1,103 @@
+package weibo
+
+import (
+	"bytes"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 图片文件的扩展名，用于判断图片是否已下载
+var picExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic"}
+
+var contentTypeExts = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/jpg":       ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/heic":      ".heic",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+}
+
+// sniffExt 根据文件头的魔数判断扩展名
+func sniffExt(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return ".gif"
+	case len(head) >= 12 && bytes.Equal(head[0:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return ".webp"
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		switch string(head[8:12]) {
+		case "heic", "heix", "mif1":
+			return ".heic"
+		case "qt  ":
+			return ".mov"
+		default:
+			return ".mp4"
+		}
+	}
+	return ""
+}
+
+// DetectExt 判断下载文件的扩展名，依次根据文件头魔数、响应的Content-Type、
+// pic_infos的type和url判断，都无法判断时返回".jpg"
+func DetectExt(head []byte, contentType string, picType string, fileUrl string) string {
+	if ext := sniffExt(head); ext != "" {
+		return ext
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if ext, ok := contentTypeExts[mediaType]; ok {
+			return ext
+		}
+	}
+	if picType == "gif" {
+		return ".gif"
+	}
+	if u, err := url.Parse(fileUrl); err == nil {
+		ext := strings.ToLower(filepath.Ext(u.Path))
+		if ext == ".jpeg" {
+			return ".jpg"
+		}
+		for _, picExt := range picExts {
+			if ext == picExt {
+				return ext
+			}
+		}
+	}
+	return ".jpg"
+}
+
+// ExistedPic 返回已下载的图片文件名，兼容按实际类型保存的扩展名
+func ExistedPic(path string, pic string) (string, bool) {
+	matches, _ := filepath.Glob(path + pic + ".*")
+	for _, match := range matches {
+		ext := strings.ToLower(filepath.Ext(match))
+		for _, picExt := range picExts {
+			if ext == picExt && strings.TrimSuffix(filepath.Base(match), filepath.Ext(match)) == filepath.Base(path+pic) {
+				return match, true
+			}
+		}
+	}
+	return "", false
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func mkdir(path string) error {
+	return os.MkdirAll(path, 0755)
+}
+
+func dirOf(name string) string {
+	return filepath.Dir(name)
+}
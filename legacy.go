@@ -0,0 +1,252 @@
+package weibo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 迁移5之前的博文表，转发的博文展开在Retweeted*字段中，图片地址以json保存
+const mblogTable = "CREATE TABLE IF NOT EXISTS mblog (UID BIGINT NOT NULL, ID BIGINT NOT NULL, MblogID VARCHAR(64) NOT NULL, TheText TEXT, Pics {json}, CreatedAt {datetime}, RetweetedUID BIGINT NOT NULL, RetweetedID BIGINT NOT NULL, RetweetedMblogID VARCHAR(64) NOT NULL, RetweetedTheText TEXT, RetweetedPics {json}, RetweetedCreatedAt {datetime}, PRIMARY KEY (UID,ID,MblogID))"
+
+const mblogColumns = "UID, ID, MblogID, TheText, Pics, CreatedAt, RetweetedUID, RetweetedID, RetweetedMblogID, RetweetedTheText, RetweetedPics, RetweetedCreatedAt"
+
+// legacyTime 读取mblog表的时间字段，早期版本的CreatedAt为CHAR(32)，
+// 保存的是"Mon Jan 02 15:04:05 -0700 2006"之类的字符串，也接受DATETIME
+type legacyTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// 驱动以字符串返回DATETIME时可能的格式，不带时区的按UTC处理
+var legacyTimeLayouts = []string{
+	time.RubyDate,
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999 -0700 -0700",
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+}
+
+func (t *legacyTime) Scan(value any) error {
+	*t = legacyTime{}
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v, true
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("scan time: unsupported type %T", value)
+	}
+	if s = strings.TrimSpace(s); s == "" {
+		return nil
+	}
+	for _, layout := range legacyTimeLayouts {
+		if tm, err := time.Parse(layout, s); err == nil {
+			t.Time, t.Valid = tm, true
+			return nil
+		}
+	}
+	tm, err := ParseCreatedAt(s, time.Now(), ChinaTimeZone)
+	if err != nil {
+		return err
+	}
+	t.Time, t.Valid = tm, true
+	return nil
+}
+
+// mblogRow mblog表的一行
+type mblogRow struct {
+	UID, ID, RetweetedUID, RetweetedID       int64
+	MblogID, RetweetedMblogID                string
+	Text, Pics, RetweetedText, RetweetedPics sql.NullString
+	CreatedAt, RetweetedCreatedAt            legacyTime
+}
+
+func readMblogRows(tx *sqlTx) ([]*mblogRow, error) {
+	rows, err := tx.Query("SELECT " + mblogColumns + " FROM mblog")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mblogs []*mblogRow
+	for rows.Next() {
+		r := &mblogRow{}
+		if err := rows.Scan(&r.UID, &r.ID, &r.MblogID, &r.Text, &r.Pics, &r.CreatedAt,
+			&r.RetweetedUID, &r.RetweetedID, &r.RetweetedMblogID, &r.RetweetedText, &r.RetweetedPics, &r.RetweetedCreatedAt); err != nil {
+			return nil, err
+		}
+		mblogs = append(mblogs, r)
+	}
+	return mblogs, rows.Err()
+}
+
+// convertMblogTimes 重建mblog表，CreatedAt和RetweetedCreatedAt由CHAR(32)的字符串转换为{datetime}，
+// 新建的数据库mblog表为空，只是重建一次
+func convertMblogTimes(tx *sqlTx) error {
+	mblogs, err := readMblogRows(tx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(tx.dialect.ddl(strings.Replace(mblogTable, " mblog (", " mblog_new (", 1))); err != nil {
+		return err
+	}
+	for _, r := range mblogs {
+		if _, err := tx.Exec("INSERT INTO mblog_new("+mblogColumns+") VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
+			r.UID, r.ID, r.MblogID, r.Text, r.Pics, nullTime(r.CreatedAt.Time),
+			r.RetweetedUID, r.RetweetedID, r.RetweetedMblogID, r.RetweetedText, r.RetweetedPics, nullTime(r.RetweetedCreatedAt.Time)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE mblog"); err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE mblog_new RENAME TO mblog")
+	return err
+}
+
+// datetimeColumns 版本7之前的全部{datetime}字段
+var datetimeColumns = [][2]string{
+	{"schema_migrations", "AppliedAt"},
+	{"mblog_snapshot", "At"},
+	{"media_blob", "CreatedAt"},
+	{"weibo_user", "UpdatedAt"},
+	{"mblog_comment", "CreatedAt"},
+	{"post", "CreatedAt"},
+	{"post", "UpdatedAt"},
+	{"user_profile", "At"},
+	{"raw_payload", "FetchedAt"},
+}
+
+// convertUTCTimes 将sqlite中以文本保存的时间改写为UTC，之前的版本按传入时的时区保存，
+// 不同时区的时间无法按文本比较，没有名称的时区还无法读取；其他数据库不需要转换
+func convertUTCTimes(tx *sqlTx) error {
+	if tx.dialect != sqliteDialect {
+		return nil
+	}
+	for _, c := range datetimeColumns {
+		table, col := c[0], c[1]
+		rows, err := tx.Query("SELECT rowid, " + col + " FROM " + table + " WHERE " + col + " IS NOT NULL")
+		if err != nil {
+			return err
+		}
+		times := map[int64]time.Time{}
+		for rows.Next() {
+			var rowid int64
+			var t legacyTime
+			if err := rows.Scan(&rowid, &t); err != nil {
+				rows.Close()
+				return fmt.Errorf("%s.%s: %w", table, col, err)
+			}
+			times[rowid] = t.Time
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		// 同一时刻以不同时区保存在主键中时合并为一行
+		for rowid, t := range times {
+			if _, err := tx.Exec("UPDATE OR REPLACE "+table+" SET "+col+" = ? WHERE rowid = ?", t, rowid); err != nil {
+				return fmt.Errorf("%s.%s: %w", table, col, err)
+			}
+		}
+	}
+	return nil
+}
+
+// storedPost 由mblog表中的字段还原博文，图片只有地址，uid为-1表示没有作者
+func storedPost(uid, id int64, mblogID, text, pics string, createdAt time.Time) *Post {
+	post := &Post{ID: id, MblogID: mblogID, Text: text, CreatedAt: createdAt}
+	if uid > 0 {
+		post.Author = &User{ID: uid}
+	}
+	var urls []string
+	json.Unmarshal([]byte(pics), &urls)
+	for _, u := range urls {
+		pic := &StoredPic{URL: u}
+		post.Pictures = append(post.Pictures, &PostMedia{ID: pic.Pid(), Type: "pic", URL: u})
+	}
+	return post
+}
+
+// convertMblogs 将mblog表的博文转换到post、post_media和media表后删除mblog表，
+// 时间字段仍为CHAR(32)的字符串时也能读取
+func convertMblogs(tx *sqlTx) error {
+	mblogs, err := readMblogRows(tx)
+	if err != nil {
+		return err
+	}
+	var posts []*Post
+	for _, r := range mblogs {
+		post := storedPost(r.UID, r.ID, r.MblogID, r.Text.String, r.Pics.String, r.CreatedAt.Time)
+		if r.RetweetedID != 0 {
+			post.Retweeted = storedPost(r.RetweetedUID, r.RetweetedID, r.RetweetedMblogID, r.RetweetedText.String, r.RetweetedPics.String, r.RetweetedCreatedAt.Time)
+		}
+		posts = append(posts, post)
+	}
+
+	for _, post := range posts {
+		if post.Retweeted != nil {
+			if err := writePost(tx, tx.dialect, post.Retweeted, saveRetweeted); err != nil {
+				return err
+			}
+		}
+		if err := writePost(tx, tx.dialect, post, saveArchived); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DROP TABLE mblog")
+	return err
+}
+
+// restoreMblogs 由抓取到的博文重建mblog表，用于回滚迁移5
+func restoreMblogs(tx *sqlTx) error {
+	if _, err := tx.Exec(tx.dialect.ddl(mblogTable)); err != nil {
+		return err
+	}
+	if tx.dialect == postgresDialect {
+		if _, err := tx.Exec(mblogTextIndex); err != nil {
+			return err
+		}
+	}
+	posts, err := readPosts(tx, "WHERE p.Archived = ?", true)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		var uid, id int64
+		var mblogID, theText string
+		var pics, rePics sql.NullString
+		var createdAt sql.NullTime
+		if post.Retweeted != nil {
+			uid = post.Retweeted.UID()
+			id = post.Retweeted.ID
+			mblogID = post.Retweeted.MblogID
+			theText = post.Retweeted.Text
+			createdAt = nullTime(post.Retweeted.CreatedAt)
+			if urls := post.Retweeted.PicUrls(); len(urls) > 0 {
+				rePicBytes, _ := json.Marshal(urls)
+				rePics = sql.NullString{String: string(rePicBytes), Valid: true}
+			}
+		}
+		if urls := post.PicUrls(); len(urls) > 0 {
+			picBytes, _ := json.Marshal(urls)
+			pics = sql.NullString{String: string(picBytes), Valid: true}
+		}
+		if _, err := tx.Exec("INSERT INTO mblog("+mblogColumns+") VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
+			post.UID(), post.ID, post.MblogID, post.Text, pics, nullTime(post.CreatedAt), uid, id, mblogID, theText, rePics, createdAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
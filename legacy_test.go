@@ -0,0 +1,83 @@
+package weibo
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 早期版本Migrate直接创建的mblog表
+const baselineMblogTable = "CREATE TABLE IF NOT EXISTS mblog (UID BIGINT NOT NULL, ID BIGINT NOT NULL, MblogID VARCHAR(64) NOT NULL, TheText TEXT, Pics TEXT, CreatedAt CHAR(32), RetweetedUID BIGINT NOT NULL, RetweetedID BIGINT NOT NULL, RetweetedMblogID VARCHAR(64) NOT NULL, RetweetedTheText TEXT, RetweetedPics TEXT, RetweetedCreatedAt CHAR(32), PRIMARY KEY (UID,ID,MblogID))"
+
+func insertBaselineMblog(t *testing.T, database *Database) {
+	t.Helper()
+	db, err := database.getdb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec("INSERT INTO mblog("+mblogColumns+") VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
+		10, 1, "abc", "text", `["https://wx1.sinaimg.cn/large/pid1.jpg"]`, "Mon Jan 02 15:04:05 +0800 2006",
+		20, 2, "def", "retweeted", `["https://wx2.sinaimg.cn/large/pid2.jpg"]`, "Sun Jan 01 08:00:00 +0800 2006"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkConvertedMblog(t *testing.T, database *Database) {
+	t.Helper()
+	post, err := database.Post(1)
+	if err != nil || post == nil {
+		t.Fatalf("Post(1) = %v, %v", post, err)
+	}
+	if post.MblogID != "abc" || post.Text != "text" || post.UID() != 10 {
+		t.Errorf("Post(1) = %+v", post)
+	}
+	if want := time.Date(2006, 1, 2, 15, 4, 5, 0, ChinaTimeZone); !post.CreatedAt.Equal(want) {
+		t.Errorf("CreatedAt = %s, want %s", post.CreatedAt, want)
+	}
+	if len(post.Pictures) != 1 || post.Pictures[0].ID != "pid1" {
+		t.Errorf("Pictures = %+v", post.Pictures)
+	}
+	re := post.Retweeted
+	if re == nil || re.ID != 2 || re.MblogID != "def" || re.Text != "retweeted" || re.UID() != 20 {
+		t.Fatalf("Retweeted = %+v", re)
+	}
+	if want := time.Date(2006, 1, 1, 8, 0, 0, 0, ChinaTimeZone); !re.CreatedAt.Equal(want) {
+		t.Errorf("Retweeted.CreatedAt = %s, want %s", re.CreatedAt, want)
+	}
+	if len(re.Pictures) != 1 || re.Pictures[0].ID != "pid2" {
+		t.Errorf("Retweeted.Pictures = %+v", re.Pictures)
+	}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	database := &Database{DN: "sqlite", DSN: filepath.Join(t.TempDir(), "weibo.db")}
+	defer database.Close()
+	db, err := database.getdb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(baselineMblogTable); err != nil {
+		t.Fatal(err)
+	}
+	insertBaselineMblog(t, database)
+
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	checkConvertedMblog(t, database)
+}
+
+// 版本2之后写入的字符串时间也能转换
+func TestConvertMblogsStringTimes(t *testing.T) {
+	database := &Database{DN: "sqlite", DSN: filepath.Join(t.TempDir(), "weibo.db")}
+	defer database.Close()
+	if err := database.MigrateTo(4); err != nil {
+		t.Fatal(err)
+	}
+	insertBaselineMblog(t, database)
+
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	checkConvertedMblog(t, database)
+}
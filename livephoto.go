@@ -0,0 +1,76 @@
+package weibo
+
+import (
+	"os"
+	"strconv"
+)
+
+// LivePhoto 实况照片的静态图和动态视频，默认布局下两者文件名相同、扩展名不同，便于相册软件识别为一组
+type LivePhoto struct {
+	ID       int64  `json:"id"` // 博文id
+	Pid      string `json:"pid"`
+	Still    string `json:"still"`  // 静态图保存的文件名或key，未下载时为空
+	Motion   string `json:"motion"` // 动态视频保存的文件名或key，未下载时为空
+	VideoURL string `json:"video_url"`
+}
+
+func NewLivePhoto(id int64, media *PostMedia) *LivePhoto {
+	return &LivePhoto{ID: id, Pid: media.ID, VideoURL: media.VideoURL}
+}
+
+// DownLivePhotoMotion 下载实况照片的动态视频到path，已存在的跳过
+func DownLivePhotoMotion(c *Client, pid string, videoUrl string, path string) error {
+	motion := pid + ".mov"
+	if _, err := os.Stat(path + motion); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	return c.downFile(videoUrl, path+motion, nil)
+}
+
+// LivePhotos 返回博文的实况照片，不含下载后的文件名
+func (p *Post) LivePhotos() []*LivePhoto {
+	var livePhotos []*LivePhoto
+	for _, media := range p.Pictures {
+		if media.Type == "livephoto" {
+			livePhotos = append(livePhotos, NewLivePhoto(p.ID, media))
+		}
+	}
+	return livePhotos
+}
+
+// LivePhotos 返回静态图和动态视频都下载成功的实况照片，Still和Motion为实际保存的文件名或key
+func (r *DownloadReport) LivePhotos() []*LivePhoto {
+	stills := make(map[[2]string]*DownloadResult)
+	for _, result := range r.Results {
+		if result != nil && result.Err == nil && result.Job.Kind == JobPic {
+			stills[livePhotoKey(result.Job)] = result
+		}
+	}
+	var livePhotos []*LivePhoto
+	for _, result := range r.Results {
+		if result == nil || result.Err != nil || result.Job.Kind != JobMotion {
+			continue
+		}
+		still, ok := stills[livePhotoKey(result.Job)]
+		if !ok {
+			continue
+		}
+		lp := &LivePhoto{Pid: result.Job.Name, Still: still.File, Motion: result.File, VideoURL: result.Job.URL}
+		if result.Job.Key != nil {
+			lp.ID = result.Job.Key.ID
+		}
+		livePhotos = append(livePhotos, lp)
+	}
+	return livePhotos
+}
+
+func livePhotoKey(job *DownloadJob) [2]string {
+	id := ""
+	if job.Key != nil {
+		id = strconv.FormatInt(job.Key.ID, 10)
+	}
+	return [2]string{id, job.Name}
+}
@@ -0,0 +1,191 @@
+package weibo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var MediaNotFound = errors.New("MediaNotFound")
+
+// PicVariant 图片的某个尺寸
+type PicVariant struct {
+	URL     string `json:"url"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	CutType int    `json:"cut_type,omitempty"`
+}
+
+// FocusPoint 图片焦点区域，取值为相对宽高的比例
+type FocusPoint struct {
+	Left   float64 `json:"left"`
+	Top    float64 `json:"top"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// PicInfo pic_infos中的单张图片
+type PicInfo struct {
+	Thumbnail     *PicVariant `json:"thumbnail,omitempty"`
+	Bmiddle       *PicVariant `json:"bmiddle,omitempty"`
+	Large         *PicVariant `json:"large,omitempty"`
+	Original      *PicVariant `json:"original,omitempty"`
+	Largest       *PicVariant `json:"largest,omitempty"`
+	Mw2000        *PicVariant `json:"mw2000,omitempty"`
+	ObjectID      string      `json:"object_id,omitempty"`
+	PicID         string      `json:"pic_id"`
+	PhotoTag      int         `json:"photo_tag,omitempty"`
+	Type          string      `json:"type"` // pic、gif、livephoto
+	PicStatus     int         `json:"pic_status,omitempty"`
+	Video         string      `json:"video,omitempty"` // livephoto的视频地址
+	VideoObjectID string      `json:"video_object_id,omitempty"`
+	FocusPoint    *FocusPoint `json:"focus_point,omitempty"`
+}
+
+// Variant 按名称返回尺寸：thumbnail、bmiddle、large、original、largest、mw2000
+func (p *PicInfo) Variant(name string) (*PicVariant, error) {
+	if p == nil {
+		return nil, MediaNotFound
+	}
+	var v *PicVariant
+	switch name {
+	case "thumbnail":
+		v = p.Thumbnail
+	case "bmiddle":
+		v = p.Bmiddle
+	case "large":
+		v = p.Large
+	case "original":
+		v = p.Original
+	case "largest":
+		v = p.Largest
+	case "mw2000":
+		v = p.Mw2000
+	default:
+		return nil, fmt.Errorf("unknown pic variant %q", name)
+	}
+	if v == nil || v.URL == "" {
+		return nil, fmt.Errorf("pic %s variant %s: %w", p.PicID, name, MediaNotFound)
+	}
+	return v, nil
+}
+
+// Best 返回可用的最大尺寸
+func (p *PicInfo) Best() (*PicVariant, error) {
+	for _, name := range []string{"largest", "original", "mw2000", "large", "bmiddle", "thumbnail"} {
+		if v, err := p.Variant(name); err == nil {
+			return v, nil
+		}
+	}
+	if p == nil {
+		return nil, MediaNotFound
+	}
+	return nil, fmt.Errorf("pic %s: %w", p.PicID, MediaNotFound)
+}
+
+func (p *PicInfo) mediaType() string {
+	if p.IsLivePhoto() {
+		return "livephoto"
+	}
+	if p.Type == "" {
+		return "pic"
+	}
+	return p.Type
+}
+
+// IsLivePhoto 是否为实况照片，Video为动态部分的视频地址
+func (p *PicInfo) IsLivePhoto() bool {
+	return p.Type == "livephoto" && p.Video != ""
+}
+
+// MixMediaInfo 图片视频混排博文的媒体列表
+type MixMediaInfo struct {
+	Items []*MixMediaItem `json:"items"`
+}
+
+type MixMediaItem struct {
+	Type string          `json:"type"` // pic、video
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Pic 解析type为pic的媒体
+func (i *MixMediaItem) Pic() (*PicInfo, error) {
+	if i.Type != "pic" {
+		return nil, fmt.Errorf("mix media %s is %s, not pic", i.ID, i.Type)
+	}
+	pic := &PicInfo{}
+	if err := json.Unmarshal(i.Data, pic); err != nil {
+		return nil, fmt.Errorf("mix media %s: %w", i.ID, err)
+	}
+	if pic.PicID == "" {
+		pic.PicID = i.ID
+	}
+	return pic, nil
+}
+
+// Video 解析type为video的媒体
+func (i *MixMediaItem) Video() (*PageInfo, error) {
+	if i.Type != "video" {
+		return nil, fmt.Errorf("mix media %s is %s, not video", i.ID, i.Type)
+	}
+	video := &PageInfo{}
+	if err := json.Unmarshal(i.Data, video); err != nil {
+		return nil, fmt.Errorf("mix media %s: %w", i.ID, err)
+	}
+	return video, nil
+}
+
+// Pictures 按顺序返回博文的图片，缺失的图片跳过并在err中说明
+func (m *Mblog) Pictures() ([]*PicInfo, error) {
+	if m == nil {
+		return nil, nil
+	}
+	var pics []*PicInfo
+	var errs []error
+	if len(m.PicInfos) > 0 {
+		for _, id := range m.PicIds {
+			pic, ok := m.PicInfos[id]
+			if !ok || pic == nil {
+				errs = append(errs, fmt.Errorf("pic %s: %w", id, MediaNotFound))
+				continue
+			}
+			if pic.PicID == "" {
+				pic.PicID = id
+			}
+			pics = append(pics, pic)
+		}
+	} else if m.MixMediaInfo != nil {
+		for _, item := range m.MixMediaInfo.Items {
+			if item.Type != "pic" {
+				continue
+			}
+			pic, err := item.Pic()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			pics = append(pics, pic)
+		}
+	}
+	return pics, errors.Join(errs...)
+}
+
+// PicUrls 返回图片id到最大尺寸地址的映射
+func (m *Mblog) PicUrls() (map[string]string, error) {
+	pics, err := m.Pictures()
+	urls := make(map[string]string)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, pic := range pics {
+		v, err := pic.Best()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		urls[pic.PicID] = v.URL
+	}
+	return urls, errors.Join(errs...)
+}
@@ -0,0 +1,115 @@
+package weibo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPicInfoBest(t *testing.T) {
+	tests := []struct {
+		pic  *PicInfo
+		want string
+	}{
+		{&PicInfo{PicID: "a", Largest: &PicVariant{URL: "largest"}, Original: &PicVariant{URL: "original"}}, "largest"},
+		{&PicInfo{PicID: "a", Largest: &PicVariant{}, Original: &PicVariant{URL: "original"}, Large: &PicVariant{URL: "large"}}, "original"},
+		{&PicInfo{PicID: "a", Mw2000: &PicVariant{URL: "mw2000"}, Large: &PicVariant{URL: "large"}}, "mw2000"},
+		{&PicInfo{PicID: "a", Bmiddle: &PicVariant{URL: "bmiddle"}, Thumbnail: &PicVariant{URL: "thumbnail"}}, "bmiddle"},
+		{&PicInfo{PicID: "a", Thumbnail: &PicVariant{URL: "thumbnail"}}, "thumbnail"},
+		{&PicInfo{PicID: "a"}, ""},
+		{nil, ""},
+	}
+	for i, tt := range tests {
+		v, err := tt.pic.Best()
+		if tt.want == "" {
+			if !errors.Is(err, MediaNotFound) {
+				t.Errorf("%d: Best() = %v, %v, want MediaNotFound", i, v, err)
+			}
+			continue
+		}
+		if err != nil || v.URL != tt.want {
+			t.Errorf("%d: Best() = %v, %v, want %s", i, v, err, tt.want)
+		}
+	}
+}
+
+func TestMblogPictures(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    string // pid=地址，按顺序
+		missing bool
+	}{
+		{
+			"pic_infos",
+			`{"pic_ids":["b","a"],"pic_infos":{
+				"a":{"type":"pic","largest":{"url":"https://wx1.sinaimg.cn/large/a.jpg"}},
+				"b":{"pic_id":"b","type":"gif","original":{"url":"https://wx1.sinaimg.cn/large/b.gif"},"thumbnail":{"url":"https://wx1.sinaimg.cn/thumb150/b.gif"}}}}`,
+			"b=https://wx1.sinaimg.cn/large/b.gif a=https://wx1.sinaimg.cn/large/a.jpg",
+			false,
+		},
+		{
+			"missing pic",
+			`{"pic_ids":["a","b"],"pic_infos":{"a":{"largest":{"url":"https://wx1.sinaimg.cn/large/a.jpg"}}}}`,
+			"a=https://wx1.sinaimg.cn/large/a.jpg",
+			true,
+		},
+		{
+			"mix_media_info",
+			`{"mix_media_info":{"items":[
+				{"type":"pic","id":"a","data":{"largest":{"url":"https://wx1.sinaimg.cn/large/a.jpg"}}},
+				{"type":"video","id":"v","data":{"object_type":"video"}},
+				{"type":"pic","id":"b","data":{"pic_id":"b","large":{"url":"https://wx1.sinaimg.cn/mw690/b.jpg"}}}]}}`,
+			"a=https://wx1.sinaimg.cn/large/a.jpg b=https://wx1.sinaimg.cn/mw690/b.jpg",
+			false,
+		},
+		{
+			"no variant",
+			`{"pic_ids":["a"],"pic_infos":{"a":{"type":"pic"}}}`,
+			"a=",
+			true,
+		},
+		{"no pictures", `{"pic_ids":[]}`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"ok":1,"id":1,"mblogid":"1",` + strings.TrimPrefix(tt.fixture, "{")))
+			}))
+			mblog, err := client.GetMblog("1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pics, picsErr := mblog.Pictures()
+			var got []string
+			for _, pic := range pics {
+				v, _ := pic.Best()
+				url := ""
+				if v != nil {
+					url = v.URL
+				}
+				got = append(got, pic.PicID+"="+url)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("Pictures() = %v, want %s", got, tt.want)
+			}
+
+			urls, err := mblog.PicUrls()
+			if (err != nil) != tt.missing || (tt.missing && !errors.Is(err, MediaNotFound)) {
+				t.Errorf("PicUrls() err = %v, Pictures() err = %v", err, picsErr)
+			}
+			want := map[string]string{}
+			for _, pair := range got {
+				if pid, url, _ := strings.Cut(pair, "="); url != "" {
+					want[pid] = url
+				}
+			}
+			if fmt.Sprint(urls) != fmt.Sprint(want) {
+				t.Errorf("PicUrls() = %v, want %v", urls, want)
+			}
+		})
+	}
+}
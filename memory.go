@@ -0,0 +1,186 @@
+package weibo
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore 保存在内存中的Store，用于测试和一次性的抓取，行为与Database一致
+type MemoryStore struct {
+	mu       sync.RWMutex
+	posts    map[int64]*Post
+	archived map[int64]bool // 抓取到的博文，只作为转发保存的博文不在其中
+	users    map[int64]*User
+	comments map[int64][]*Comments
+	media    map[int64][]*Media
+}
+
+func (s *MemoryStore) Migrate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.posts == nil {
+		s.posts = map[int64]*Post{}
+		s.archived = map[int64]bool{}
+		s.users = map[int64]*User{}
+		s.comments = map[int64][]*Comments{}
+		s.media = map[int64][]*Media{}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() {}
+
+// HasPost 博文是否已抓取，只作为转发保存的博文不算
+func (s *MemoryStore) HasPost(post *Post) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.archived[post.ID], nil
+}
+
+// AddPost 保存博文及其转发的博文，已存在时更新；已保存的被转发博文不覆盖
+func (s *MemoryStore) AddPost(post *Post) error {
+	s.Migrate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := post.Retweeted; r != nil {
+		if _, ok := s.posts[r.ID]; !ok {
+			s.posts[r.ID] = r
+		}
+	}
+	s.posts[post.ID] = post
+	s.archived[post.ID] = true
+	return nil
+}
+
+// UpdatePost 与AddPost相同，已存在的博文会被更新
+func (s *MemoryStore) UpdatePost(post *Post) error {
+	return s.AddPost(post)
+}
+
+// post 返回保存的博文，转发的博文取已保存的版本
+func (s *MemoryStore) post(id int64) *Post {
+	post, ok := s.posts[id]
+	if !ok {
+		return nil
+	}
+	if post.Retweeted != nil {
+		copied := *post
+		copied.Retweeted = s.post(post.Retweeted.ID)
+		return &copied
+	}
+	return post
+}
+
+// Post 返回博文，包括只作为转发保存的博文
+func (s *MemoryStore) Post(id int64) (*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.post(id), nil
+}
+
+// Posts 查询抓取到的博文，不包括只作为转发保存的博文
+func (s *MemoryStore) Posts(query *PostQuery) ([]*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var posts []*Post
+	for id := range s.archived {
+		if post := s.post(id); query.match(post) {
+			posts = append(posts, post)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].CreatedAt.Equal(posts[j].CreatedAt) {
+			return posts[i].ID > posts[j].ID
+		}
+		return posts[i].CreatedAt.After(posts[j].CreatedAt)
+	})
+	if query != nil && query.Limit > 0 && len(posts) > query.Limit {
+		posts = posts[:query.Limit]
+	}
+	return posts, nil
+}
+
+func (s *MemoryStore) HasUser(id int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.users[id]
+	return ok, nil
+}
+
+func (s *MemoryStore) AddUser(user *User) error {
+	s.Migrate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *MemoryStore) User(id int64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[id], nil
+}
+
+func (s *MemoryStore) AddComments(id int64, comments []*Comments) error {
+	s.Migrate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, comment := range flattenComments(comments) {
+		replaced := false
+		for i, c := range s.comments[id] {
+			if c.Id == comment.Id {
+				s.comments[id][i], replaced = comment, true
+				break
+			}
+		}
+		if !replaced {
+			s.comments[id] = append(s.comments[id], comment)
+		}
+	}
+	return nil
+}
+
+// Comments 按时间顺序返回博文的全部评论，时间相同时按id排序
+func (s *MemoryStore) Comments(id int64) ([]*Comments, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	comments := append([]*Comments{}, s.comments[id]...)
+	times := make(map[int64]time.Time, len(comments))
+	for _, c := range comments {
+		times[c.Id] = createdTime(c.CreatedTime, c.CreatedAt, c.Fetched)
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		ti, tj := times[comments[i].Id], times[comments[j].Id]
+		if ti.Equal(tj) {
+			return comments[i].Id < comments[j].Id
+		}
+		return ti.Before(tj)
+	})
+	return comments, nil
+}
+
+func (s *MemoryStore) AddMedia(media []*Media) error {
+	s.Migrate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range media {
+		replaced := false
+		for i, old := range s.media[m.ID] {
+			if old.Kind == m.Kind && old.Idx == m.Idx {
+				s.media[m.ID][i], replaced = m, true
+				break
+			}
+		}
+		if !replaced {
+			s.media[m.ID] = append(s.media[m.ID], m)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Media(id int64) ([]*Media, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Media{}, s.media[id]...), nil
+}
@@ -0,0 +1,116 @@
+package weibo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// MidToMblogID 将数字mid转为base62的mblogid（bid）
+// mid从低位起每7位十进制转为4位base62，最高一组不补零
+func MidToMblogID(mid int64) (string, error) {
+	if mid <= 0 {
+		return "", fmt.Errorf("invalid mid %d", mid)
+	}
+	digits := strconv.FormatInt(mid, 10)
+	var groups []string
+	for end := len(digits); end > 0; end -= 7 {
+		start := end - 7
+		if start < 0 {
+			start = 0
+		}
+		n, _ := strconv.ParseInt(digits[start:end], 10, 64)
+		group := encodeBase62(n)
+		if start > 0 {
+			group = strings.Repeat("0", 4-len(group)) + group
+		}
+		groups = append([]string{group}, groups...)
+	}
+	return strings.Join(groups, ""), nil
+}
+
+// MblogIDToMid 将base62的mblogid（bid）转为数字mid
+// mblogid从低位起每4位base62转为7位十进制，最高一组不补零
+func MblogIDToMid(mblogid string) (int64, error) {
+	if mblogid == "" {
+		return 0, fmt.Errorf("invalid mblogid %q", mblogid)
+	}
+	var groups []string
+	for end := len(mblogid); end > 0; end -= 4 {
+		start := end - 4
+		if start < 0 {
+			start = 0
+		}
+		n, err := decodeBase62(mblogid[start:end])
+		if err != nil {
+			return 0, fmt.Errorf("invalid mblogid %q: %w", mblogid, err)
+		}
+		group := strconv.FormatInt(n, 10)
+		if start > 0 {
+			if len(group) > 7 {
+				return 0, fmt.Errorf("invalid mblogid %q", mblogid)
+			}
+			group = strings.Repeat("0", 7-len(group)) + group
+		}
+		groups = append([]string{group}, groups...)
+	}
+	mid, err := strconv.ParseInt(strings.Join(groups, ""), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mblogid %q: %w", mblogid, err)
+	}
+	return mid, nil
+}
+
+// fillMblogID 补全mblog及其转发博文缺失的ID或MblogID
+func fillMblogID(mblog *Mblog) {
+	for m := mblog; m != nil; m = m.Retweeted {
+		m.ID, m.MblogID = completeMblogID(m.ID, m.MblogID)
+	}
+}
+
+// completeMblogID 由id和mblogid中的一个补全另一个
+func completeMblogID(id int64, mblogID string) (int64, string) {
+	if mblogID == "" && id > 0 {
+		mblogID, _ = MidToMblogID(id)
+	} else if id == 0 && mblogID != "" {
+		id, _ = MblogIDToMid(mblogID)
+	}
+	return id, mblogID
+}
+
+// fillCMblogID 补全手机端博文缺失的bid
+func fillCMblogID(mblog *CMblog) {
+	for m := mblog; m != nil; m = m.Retweeted {
+		if m.MblogID == "" {
+			if mid, err := m.Mid(); err == nil {
+				m.MblogID, _ = MidToMblogID(mid)
+			}
+		}
+	}
+}
+
+func encodeBase62(n int64) string {
+	if n == 0 {
+		return "0"
+	}
+	var b []byte
+	for n > 0 {
+		b = append([]byte{base62Alphabet[n%62]}, b...)
+		n /= 62
+	}
+	return string(b)
+}
+
+func decodeBase62(s string) (int64, error) {
+	var n int64
+	for _, r := range s {
+		i := strings.IndexRune(base62Alphabet, r)
+		if i < 0 {
+			return 0, fmt.Errorf("invalid base62 character %q", r)
+		}
+		n = n*62 + int64(i)
+	}
+	return n, nil
+}
@@ -0,0 +1,54 @@
+package weibo
+
+import "testing"
+
+func TestMidToMblogID(t *testing.T) {
+	tests := []struct {
+		mid     int64
+		mblogid string
+	}{
+		{3501756485200075, "z0JH2lOMb"},
+		{1, "1"},
+		{9999999, "FXsj"},
+		{10000000, "10000"}, // 低位一组为0，补足4位
+		{10000001, "10001"},
+		{20000000000000, "8oi40000"},
+		{3500000000000001, "z00000001"},
+	}
+	for _, tt := range tests {
+		got, err := MidToMblogID(tt.mid)
+		if err != nil {
+			t.Errorf("MidToMblogID(%d): %v", tt.mid, err)
+		} else if got != tt.mblogid {
+			t.Errorf("MidToMblogID(%d) = %q, want %q", tt.mid, got, tt.mblogid)
+		}
+		mid, err := MblogIDToMid(tt.mblogid)
+		if err != nil {
+			t.Errorf("MblogIDToMid(%q): %v", tt.mblogid, err)
+		} else if mid != tt.mid {
+			t.Errorf("MblogIDToMid(%q) = %d, want %d", tt.mblogid, mid, tt.mid)
+		}
+	}
+}
+
+func TestMidToMblogIDInvalid(t *testing.T) {
+	for _, mid := range []int64{0, -1} {
+		if got, err := MidToMblogID(mid); err == nil {
+			t.Errorf("MidToMblogID(%d) = %q, want error", mid, got)
+		}
+	}
+}
+
+func TestMblogIDToMidInvalid(t *testing.T) {
+	for _, mblogid := range []string{
+		"",
+		"z0JH2-OMb",            // 非base62字符
+		"z0JH2lOM/",            // 非base62字符
+		"1ZZZZ",                // 低位一组超过7位十进制
+		"zzzzzzzzzzzzzzzzzzzz", // 超出int64
+	} {
+		if got, err := MblogIDToMid(mblogid); err == nil {
+			t.Errorf("MblogIDToMid(%q) = %d, want error", mblogid, got)
+		}
+	}
+}
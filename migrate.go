@@ -0,0 +1,362 @@
+package weibo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration 一个版本的数据库结构变更，Up和Down以方言名为key，""为各数据库通用的语句，
+// 语句中的{datetime}、{bool}、{json}、{longjson}、{blob}按方言替换为实际类型
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[string][]string
+	Down    map[string][]string
+
+	up   func(tx *sqlTx) error // 数据迁移，在Up语句之后执行
+	down func(tx *sqlTx) error // 在Down语句之前执行
+}
+
+func (m *Migration) statements(d *dialect, up bool) []string {
+	stmts := m.Down
+	if up {
+		stmts = m.Up
+	}
+	if s, ok := stmts[d.name]; ok {
+		return s
+	}
+	return stmts[""]
+}
+
+// 版本1、3、4的表在引入迁移之前由Migrate直接创建，因此使用IF NOT EXISTS，
+// 版本2将早期以CHAR(32)保存的mblog时间字段改为{datetime}，版本5将mblog表转换为规范化的表结构，
+// 版本7将sqlite中以各种时区保存的时间统一为UTC，版本8为mblog_entity增加链接标题，
+// 版本9记录每个pid对应的blob，内容相同的不同pid共用一个blob，
+// 版本10将postgres中raw_payload的Payload由JSONB改为TEXT，保存原样的JSON
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "mblog",
+		Up: map[string][]string{"": {
+			mblogTable,
+			"CREATE TABLE IF NOT EXISTS mblog_entity (ID BIGINT NOT NULL, Idx INT NOT NULL, Type VARCHAR(16) NOT NULL, Text TEXT, StartOffset INT NOT NULL, EndOffset INT NOT NULL, Name VARCHAR(255), UID BIGINT, URL TEXT, LongURL TEXT, PRIMARY KEY (ID,Idx))",
+			"CREATE TABLE IF NOT EXISTS livephoto (ID BIGINT NOT NULL, Pid VARCHAR(64) NOT NULL, Still VARCHAR(255) NOT NULL, Motion VARCHAR(255) NOT NULL, VideoURL TEXT, PRIMARY KEY (ID,Pid))",
+			"CREATE TABLE IF NOT EXISTS mblog_snapshot (ID BIGINT NOT NULL, MblogID VARCHAR(64) NOT NULL, At {datetime} NOT NULL, RepostsCount BIGINT NOT NULL, CommentsCount BIGINT NOT NULL, AttitudesCount BIGINT NOT NULL, PRIMARY KEY (ID,At))",
+		}},
+		Down: map[string][]string{"": {
+			"DROP TABLE mblog_snapshot",
+			"DROP TABLE livephoto",
+			"DROP TABLE mblog_entity",
+			"DROP TABLE mblog",
+		}},
+	},
+	{
+		Version: 2,
+		Name:    "mblog_datetime",
+		up:      convertMblogTimes,
+	},
+	{
+		Version: 3,
+		Name:    "media_dedup",
+		Up: map[string][]string{"": {
+			"CREATE TABLE IF NOT EXISTS media_blob (SHA256 CHAR(64) NOT NULL, Pid VARCHAR(64), Ext VARCHAR(8) NOT NULL, Size BIGINT NOT NULL, BlobKey VARCHAR(255) NOT NULL, CreatedAt {datetime}, PRIMARY KEY (SHA256))",
+			"CREATE TABLE IF NOT EXISTS media_ref (ID BIGINT NOT NULL, MediaKey VARCHAR(255) NOT NULL, SHA256 CHAR(64) NOT NULL, Kind VARCHAR(16) NOT NULL, Idx INT NOT NULL, PRIMARY KEY (ID,MediaKey))",
+		}},
+		Down: map[string][]string{"": {
+			"DROP TABLE media_ref",
+			"DROP TABLE media_blob",
+		}},
+	},
+	{
+		Version: 4,
+		Name:    "user_comment_media",
+		Up: map[string][]string{
+			"": {
+				"CREATE TABLE IF NOT EXISTS weibo_user (ID BIGINT NOT NULL, ScreenName VARCHAR(255), Avatar TEXT, Description TEXT, Gender VARCHAR(8), Location VARCHAR(255), FollowersCount BIGINT, FriendsCount BIGINT, StatusesCount BIGINT, Verified {bool}, VerifiedType INT, VerifiedReason TEXT, UpdatedAt {datetime}, PRIMARY KEY (ID))",
+				"CREATE TABLE IF NOT EXISTS mblog_comment (ID BIGINT NOT NULL, MblogID BIGINT NOT NULL, RootID BIGINT NOT NULL, UID BIGINT NOT NULL, ScreenName VARCHAR(255), Text TEXT, TextRaw TEXT, Source VARCHAR(255), LikeCounts INT, CreatedAt {datetime}, PRIMARY KEY (ID))",
+				"CREATE TABLE IF NOT EXISTS media (ID BIGINT NOT NULL, Kind VARCHAR(16) NOT NULL, Idx INT NOT NULL, Pid VARCHAR(64) NOT NULL, URL TEXT, MediaKey VARCHAR(255), Size BIGINT, SHA256 CHAR(64), PRIMARY KEY (ID,Kind,Idx))",
+			},
+			// simple配置按空白和标点分词，中文需要zhparser等插件才能按词检索
+			"postgres": {
+				"CREATE TABLE IF NOT EXISTS weibo_user (ID BIGINT NOT NULL, ScreenName VARCHAR(255), Avatar TEXT, Description TEXT, Gender VARCHAR(8), Location VARCHAR(255), FollowersCount BIGINT, FriendsCount BIGINT, StatusesCount BIGINT, Verified {bool}, VerifiedType INT, VerifiedReason TEXT, UpdatedAt {datetime}, PRIMARY KEY (ID))",
+				"CREATE TABLE IF NOT EXISTS mblog_comment (ID BIGINT NOT NULL, MblogID BIGINT NOT NULL, RootID BIGINT NOT NULL, UID BIGINT NOT NULL, ScreenName VARCHAR(255), Text TEXT, TextRaw TEXT, Source VARCHAR(255), LikeCounts INT, CreatedAt {datetime}, PRIMARY KEY (ID))",
+				"CREATE TABLE IF NOT EXISTS media (ID BIGINT NOT NULL, Kind VARCHAR(16) NOT NULL, Idx INT NOT NULL, Pid VARCHAR(64) NOT NULL, URL TEXT, MediaKey VARCHAR(255), Size BIGINT, SHA256 CHAR(64), PRIMARY KEY (ID,Kind,Idx))",
+				mblogTextIndex,
+				"CREATE INDEX IF NOT EXISTS mblog_comment_text_idx ON mblog_comment USING GIN (to_tsvector('simple', COALESCE(Text, '')))",
+			},
+		},
+		Down: map[string][]string{
+			"": {
+				"DROP TABLE media",
+				"DROP TABLE mblog_comment",
+				"DROP TABLE weibo_user",
+			},
+			"postgres": {
+				"DROP INDEX IF EXISTS mblog_text_idx",
+				"DROP TABLE media",
+				"DROP TABLE mblog_comment",
+				"DROP TABLE weibo_user",
+			},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "normalize",
+		Up: map[string][]string{
+			"":         normalizeTables,
+			"postgres": append(normalizeTables[:len(normalizeTables):len(normalizeTables)], "CREATE INDEX post_text_idx ON post USING GIN (to_tsvector('simple', COALESCE(Text, '')))"),
+		},
+		Down: map[string][]string{"": {
+			"DROP TABLE post_media",
+			"DROP TABLE media",
+			"ALTER TABLE media_file RENAME TO media",
+			"DROP TABLE user_profile",
+			"DROP TABLE post",
+		}},
+		up:   convertMblogs,
+		down: restoreMblogs,
+	},
+	{
+		Version: 6,
+		Name:    "raw_payload",
+		Up: map[string][]string{"": {
+			"CREATE TABLE raw_payload (Kind VARCHAR(16) NOT NULL, ID BIGINT NOT NULL, ParentID BIGINT, Endpoint VARCHAR(255), FetchedAt {datetime} NOT NULL, Payload {longjson}, Compressed {blob}, PRIMARY KEY (Kind,ID,FetchedAt))",
+		}},
+		Down: map[string][]string{"": {
+			"DROP TABLE raw_payload",
+		}},
+	},
+	{
+		Version: 7,
+		Name:    "sqlite_utc",
+		up:      convertUTCTimes,
+	},
+	{
+		Version: 8,
+		Name:    "entity_title",
+		Up: map[string][]string{"": {
+			"ALTER TABLE mblog_entity ADD COLUMN Title TEXT",
+		}},
+		Down: map[string][]string{"": {
+			"ALTER TABLE mblog_entity DROP COLUMN Title",
+		}},
+	},
+	{
+		Version: 9,
+		Name:    "media_pid",
+		Up: map[string][]string{"": {
+			"CREATE TABLE media_pid (Pid VARCHAR(64) NOT NULL, SHA256 CHAR(64) NOT NULL, PRIMARY KEY (Pid))",
+			"CREATE INDEX media_pid_sha256_idx ON media_pid (SHA256)",
+			"INSERT INTO media_pid(Pid, SHA256) SELECT Pid, MIN(SHA256) FROM media_blob WHERE Pid IS NOT NULL AND Pid <> '' GROUP BY Pid",
+		}},
+		Down: map[string][]string{"": {
+			"DROP TABLE media_pid",
+		}},
+	},
+	{
+		Version: 10,
+		Name:    "raw_payload_text",
+		Up: map[string][]string{"postgres": {
+			"ALTER TABLE raw_payload ALTER COLUMN Payload TYPE TEXT",
+		}},
+		Down: map[string][]string{"postgres": {
+			"ALTER TABLE raw_payload ALTER COLUMN Payload TYPE JSONB USING Payload::jsonb",
+		}},
+	},
+}
+
+// simple配置按空白和标点分词，中文需要zhparser等插件才能按词检索
+const mblogTextIndex = "CREATE INDEX IF NOT EXISTS mblog_text_idx ON mblog USING GIN (to_tsvector('simple', COALESCE(TheText, '')))"
+
+// normalizeTables 规范化的表结构：post以RetweetedID引用转发的博文，作者为空时UID为NULL；
+// media按pid保存图片，post_media记录博文中的图片顺序；user_profile记录用户资料的历史；
+// 原media表记录的是下载的文件，改名为media_file
+var normalizeTables = []string{
+	"ALTER TABLE media RENAME TO media_file",
+	"CREATE TABLE post (ID BIGINT NOT NULL, MblogID VARCHAR(64) NOT NULL, UID BIGINT, Text TEXT, IsLongText {bool} NOT NULL DEFAULT FALSE, CreatedAt {datetime}, Source VARCHAR(255), Region VARCHAR(64), RetweetedID BIGINT, RepostsCount BIGINT, CommentsCount BIGINT, AttitudesCount BIGINT, Archived {bool} NOT NULL DEFAULT FALSE, UpdatedAt {datetime}, PRIMARY KEY (ID), FOREIGN KEY (UID) REFERENCES weibo_user(ID), FOREIGN KEY (RetweetedID) REFERENCES post(ID))",
+	"CREATE INDEX post_mblogid_idx ON post (MblogID)",
+	"CREATE INDEX post_created_idx ON post (CreatedAt)",
+	"CREATE TABLE media (Pid VARCHAR(64) NOT NULL, Type VARCHAR(16), URL TEXT, VideoURL TEXT, PRIMARY KEY (Pid))",
+	"CREATE TABLE post_media (PostID BIGINT NOT NULL, Idx INT NOT NULL, Pid VARCHAR(64) NOT NULL, PRIMARY KEY (PostID,Idx), FOREIGN KEY (PostID) REFERENCES post(ID), FOREIGN KEY (Pid) REFERENCES media(Pid))",
+	"CREATE TABLE user_profile (UID BIGINT NOT NULL, At {datetime} NOT NULL, ScreenName VARCHAR(255), Avatar TEXT, Description TEXT, Gender VARCHAR(8), Location VARCHAR(255), FollowersCount BIGINT, FriendsCount BIGINT, StatusesCount BIGINT, Verified {bool}, VerifiedType INT, VerifiedReason TEXT, PRIMARY KEY (UID,At))",
+}
+
+// MigrationStatus 迁移的执行情况
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time // 未执行时为零值
+}
+
+// 多个进程同时迁移时使用的锁
+const (
+	migrationLock        = "weibo_schema_migrations"
+	migrationLockID      = 0x7765_6962_6f00 // postgres advisory lock
+	migrationLockTimeout = 60               // mysql GET_LOCK的超时秒数
+)
+
+// migrationConn 持有迁移锁的连接，迁移语句都在该连接上执行
+type migrationConn struct {
+	*sql.Conn
+	dialect *dialect
+}
+
+func (conn *migrationConn) exec(ctx context.Context, query string, args ...any) error {
+	_, err := conn.ExecContext(ctx, conn.dialect.rebind(query), conn.dialect.args(args)...)
+	return err
+}
+
+// locked 获取迁移锁后执行fn，mysql使用GET_LOCK，postgres使用advisory lock，sqlite的写入本身互斥
+func (database *Database) locked(fn func(ctx context.Context, conn *migrationConn) error) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	conn := &migrationConn{Conn: c, dialect: db.dialect}
+
+	switch db.dialect {
+	case mysqlDialect:
+		var ok sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLock, migrationLockTimeout).Scan(&ok); err != nil {
+			return err
+		}
+		if ok.Int64 != 1 {
+			return fmt.Errorf("migration lock %s timeout", migrationLock)
+		}
+		defer conn.exec(ctx, "DO RELEASE_LOCK(?)", migrationLock)
+	case postgresDialect:
+		if err := conn.exec(ctx, "SELECT pg_advisory_lock(?)", migrationLockID); err != nil {
+			return err
+		}
+		defer conn.exec(ctx, "SELECT pg_advisory_unlock(?)", migrationLockID)
+	}
+
+	if err := conn.exec(ctx, conn.dialect.ddl("CREATE TABLE IF NOT EXISTS schema_migrations (Version BIGINT NOT NULL, Name VARCHAR(255) NOT NULL, AppliedAt {datetime} NOT NULL, PRIMARY KEY (Version))")); err != nil {
+		return err
+	}
+	return fn(ctx, conn)
+}
+
+func (conn *migrationConn) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT Version, AppliedAt FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// apply 在事务中执行一个迁移并更新schema_migrations，mysql的DDL会隐式提交，失败时需要手动处理
+func (conn *migrationConn) apply(ctx context.Context, m *Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if !up && m.down != nil {
+		if err := m.down(&sqlTx{Tx: tx, dialect: conn.dialect}); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+	for _, stmt := range m.statements(conn.dialect, up) {
+		if _, err := tx.ExecContext(ctx, conn.dialect.ddl(stmt)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+	if up && m.up != nil {
+		if err := m.up(&sqlTx{Tx: tx, dialect: conn.dialect}); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, conn.dialect.rebind("INSERT INTO schema_migrations(Version, Name, AppliedAt) VALUES(?,?,?)"), conn.dialect.args([]any{m.Version, m.Name, time.Now()})...)
+	} else {
+		_, err = tx.ExecContext(ctx, conn.dialect.rebind("DELETE FROM schema_migrations WHERE Version = ?"), m.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Migrate 执行全部未执行的迁移
+func (database *Database) Migrate() error {
+	return database.MigrateTo(migrations[len(migrations)-1].Version)
+}
+
+// MigrateTo 按版本顺序执行不超过version的未执行迁移
+func (database *Database) MigrateTo(version int) error {
+	return database.locked(func(ctx context.Context, conn *migrationConn) error {
+		applied, err := conn.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok || m.Version > version {
+				continue
+			}
+			if err := conn.apply(ctx, m, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback 按版本倒序回滚最近执行的steps个迁移
+func (database *Database) Rollback(steps int) error {
+	return database.locked(func(ctx context.Context, conn *migrationConn) error {
+		applied, err := conn.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := conn.apply(ctx, m, false); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatus 按版本顺序返回全部迁移的执行情况
+func (database *Database) MigrationStatus() ([]*MigrationStatus, error) {
+	var status []*MigrationStatus
+	err := database.locked(func(ctx context.Context, conn *migrationConn) error {
+		applied, err := conn.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status = append(status, &MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version]})
+		}
+		return nil
+	})
+	return status, err
+}
@@ -2,8 +2,8 @@ package weibo
 
 import (
 	"fmt"
-	strip "github.com/grokify/html-strip-tags-go"
-	"strings"
+	"strconv"
+	"time"
 )
 
 type CMblogBody struct {
@@ -29,18 +29,27 @@ type CardGroup struct {
 }
 
 type CMblog struct {
-	CreatedAt   string      `json:"created_at"`
-	ID          string      `json:"id"`
-	Text        string      `json:"text"`
-	PicIds      []string    `json:"pic_ids"`
-	User        *User       `json:"user"`
-	IsLongText  bool        `json:"isLongText"`
-	ActionInfo  *ActionInfo `json:"action_info"`
-	PicNum      int8        `json:"pic_num"`
-	MblogID     string      `json:"bid"`
-	Pics        []*Pics     `json:"pics,omitempty"`
-	Retweeted   *CMblog     `json:"retweeted_status,omitempty"`
-	LongTextRaw string
+	CreatedAt  string      `json:"created_at"`
+	ID         string      `json:"id"`
+	Text       string      `json:"text"`
+	PicIds     []string    `json:"pic_ids"`
+	User       *User       `json:"user"`
+	IsLongText bool        `json:"isLongText"`
+	ActionInfo *ActionInfo `json:"action_info"`
+	PicNum     int8        `json:"pic_num"`
+	MblogID    string      `json:"bid"`
+	Pics       []*Pics     `json:"pics,omitempty"`
+	PageInfo   *PageInfo   `json:"page_info,omitempty"`
+	Retweeted  *CMblog     `json:"retweeted_status,omitempty"`
+	Source     string      `json:"source"`
+	RegionName string      `json:"region_name"`
+
+	RepostsCount   Count `json:"reposts_count"`
+	CommentsCount  Count `json:"comments_count"`
+	AttitudesCount Count `json:"attitudes_count"`
+	LongTextRaw    string
+	CreatedTime    time.Time `json:"-"`
+	Fetched        *Fetched  `json:"-"`
 }
 
 type ActionInfo struct {
@@ -60,9 +69,15 @@ type CommentBlog struct {
 }
 
 type Pics struct {
-	Pid   string `json:"pid"`
-	Url   string `json:"url"`
-	Large *Large `json:"large"`
+	Pid      string `json:"pid"`
+	Url      string `json:"url"`
+	Large    *Large `json:"large"`
+	Type     string `json:"type,omitempty"`     // 实况照片为livephotos
+	VideoSrc string `json:"videoSrc,omitempty"` // 实况照片的视频地址
+}
+
+func (p *Pics) IsLivePhoto() bool {
+	return p.Type == "livephotos" && p.VideoSrc != ""
 }
 
 type Large struct {
@@ -73,10 +88,15 @@ func (m *CMblog) TheText() string {
 	if m.LongTextRaw != "" {
 		return m.LongTextRaw
 	}
-	text := strings.ReplaceAll(m.Text, "<br />", "\n")
-	text = strip.StripTags(text)
+	return (&Renderer{}).RenderHTML(m.Text)
+}
 
-	return text
+// Mid 返回数字形式的博文id
+func (m *CMblog) Mid() (int64, error) {
+	if m.ID != "" {
+		return strconv.ParseInt(m.ID, 10, 64)
+	}
+	return MblogIDToMid(m.MblogID)
 }
 
 func (c *Client) FetchCMblogLongText(mblog *CMblog) error {
@@ -113,6 +133,7 @@ func (c *Client) GetCMblogs(userid string, page int, longtext bool) ([]*CMblog,
 			if len(card.CardGroup) == 0 || card.SkipGroupTitle {
 				continue
 			}
+			c.prepareCMblog(&card.CardGroup[0].Mblog)
 			if longtext {
 				if err := c.FetchCMblogLongText(&card.CardGroup[0].Mblog); err != nil {
 					return nil, err
@@ -125,6 +146,7 @@ func (c *Client) GetCMblogs(userid string, page int, longtext bool) ([]*CMblog,
 			}
 			mblogs = append(mblogs, &card.CardGroup[0].Mblog)
 		} else if card.CardType == 9 {
+			c.prepareCMblog(&card.Mblog)
 			if longtext {
 				if err := c.FetchCMblogLongText(&card.Mblog); err != nil {
 					return nil, err
@@ -159,6 +181,7 @@ func (c *Client) GetMMblogs(userid string, page int, longtext bool) ([]*CMblog,
 			if len(card.CardGroup) == 0 || card.SkipGroupTitle {
 				continue
 			}
+			c.prepareCMblog(&card.CardGroup[0].Mblog)
 			if longtext {
 				if err := c.FetchCMblogLongText(&card.CardGroup[0].Mblog); err != nil {
 					return nil, err
@@ -171,6 +194,7 @@ func (c *Client) GetMMblogs(userid string, page int, longtext bool) ([]*CMblog,
 			}
 			mblogs = append(mblogs, &card.CardGroup[0].Mblog)
 		} else if card.CardType == 9 {
+			c.prepareCMblog(&card.Mblog)
 			if longtext {
 				if err := c.FetchCMblogLongText(&card.Mblog); err != nil {
 					return nil, err
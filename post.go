@@ -0,0 +1,173 @@
+package weibo
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Post PC端Mblog和手机端CMblog统一后的博文模型，存储、导出和通知只需处理Post
+type Post struct {
+	ID             int64        `json:"id"`
+	MblogID        string       `json:"mblogid"`
+	Author         *User        `json:"author,omitempty"`
+	Text           string       `json:"text"` // 纯文本，长文本已展开
+	HTML           string       `json:"html,omitempty"`
+	IsLongText     bool         `json:"is_long_text"`
+	CreatedAt      time.Time    `json:"created_at"`
+	Source         string       `json:"source,omitempty"`
+	Region         string       `json:"region,omitempty"`
+	Pictures       []*PostMedia `json:"pictures,omitempty"`
+	Videos         []*Video     `json:"videos,omitempty"`
+	Retweeted      *Post        `json:"retweeted,omitempty"`
+	Entities       []*Entity    `json:"entities,omitempty"`
+	RepostsCount   Count        `json:"reposts_count"`
+	CommentsCount  Count        `json:"comments_count"`
+	AttitudesCount Count        `json:"attitudes_count"`
+}
+
+type PostMedia struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"` // pic、gif、livephoto
+	URL      string `json:"url"`
+	VideoURL string `json:"video_url,omitempty"` // 实况照片的视频地址
+}
+
+// UID 返回作者uid，作者缺失（如转发的博文已删除）时返回-1
+func (p *Post) UID() int64 {
+	if p.Author == nil {
+		return -1
+	}
+	return p.Author.ID
+}
+
+// PicUrls 按顺序返回图片地址
+func (p *Post) PicUrls() []string {
+	var urls []string
+	for _, pic := range p.Pictures {
+		urls = append(urls, pic.URL)
+	}
+	return urls
+}
+
+var countRe = regexp.MustCompile(`^([\d.]+)(万|亿)?`)
+
+// Count 互动数，兼容接口返回的数字和"100万+"之类的字符串
+type Count int64
+
+func (c *Count) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*c = parseCount(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	if i, err := n.Int64(); err == nil {
+		*c = Count(i)
+	} else if f, err := n.Float64(); err == nil {
+		*c = Count(f)
+	}
+	return nil
+}
+
+// parseCount 解析"1.2万"、"100万+"、"12万次播放"之类的字符串
+func parseCount(s string) Count {
+	m := countRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0
+	}
+	switch m[2] {
+	case "万":
+		f *= 1e4
+	case "亿":
+		f *= 1e8
+	}
+	return Count(f)
+}
+
+// Post 转换为统一的博文模型
+func (m *Mblog) Post() *Post {
+	if m == nil {
+		return nil
+	}
+	id, mblogID := completeMblogID(m.ID, m.MblogID)
+	post := &Post{
+		ID:             id,
+		MblogID:        mblogID,
+		Author:         m.User,
+		Text:           m.TheText(),
+		HTML:           m.Text,
+		IsLongText:     m.IsLongText,
+		CreatedAt:      createdTime(m.CreatedTime, m.CreatedAt, m.Fetched),
+		Source:         m.Source,
+		Region:         m.RegionName,
+		Retweeted:      m.Retweeted.Post(),
+		Entities:       m.Entities(),
+		Videos:         m.videos(),
+		RepostsCount:   m.RepostsCount,
+		CommentsCount:  m.CommentsCount,
+		AttitudesCount: m.AttitudesCount,
+	}
+	pics, _ := m.Pictures()
+	for _, pic := range pics {
+		if v, err := pic.Best(); err == nil {
+			media := &PostMedia{ID: pic.PicID, Type: pic.mediaType(), URL: v.URL}
+			if pic.IsLivePhoto() {
+				media.VideoURL = pic.Video
+			}
+			post.Pictures = append(post.Pictures, media)
+		}
+	}
+	return post
+}
+
+// Post 转换为统一的博文模型
+func (m *CMblog) Post() *Post {
+	if m == nil {
+		return nil
+	}
+	id, _ := m.Mid()
+	_, mblogID := completeMblogID(id, m.MblogID)
+	post := &Post{
+		ID:             id,
+		MblogID:        mblogID,
+		Author:         m.User,
+		Text:           m.TheText(),
+		HTML:           m.Text,
+		IsLongText:     m.IsLongText,
+		CreatedAt:      createdTime(m.CreatedTime, m.CreatedAt, m.Fetched),
+		Source:         m.Source,
+		Region:         m.RegionName,
+		Retweeted:      m.Retweeted.Post(),
+		Entities:       m.Entities(),
+		Videos:         m.videos(),
+		RepostsCount:   m.RepostsCount,
+		CommentsCount:  m.CommentsCount,
+		AttitudesCount: m.AttitudesCount,
+	}
+	for _, pic := range m.Pics {
+		picUrl := pic.Url
+		if pic.Large != nil && pic.Large.Url != "" {
+			picUrl = pic.Large.Url
+		}
+		media := &PostMedia{ID: pic.Pid, Type: "pic", URL: picUrl}
+		if pic.IsLivePhoto() {
+			media.Type = "livephoto"
+			media.VideoURL = pic.VideoSrc
+		}
+		post.Pictures = append(post.Pictures, media)
+	}
+	return post
+}
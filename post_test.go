@@ -0,0 +1,23 @@
+package weibo
+
+import "testing"
+
+func TestPostDoesNotModifyMblog(t *testing.T) {
+	const mid, bid = 3501756485200075, "z0JH2lOMb"
+	mblog := &Mblog{ID: mid, Retweeted: &Mblog{MblogID: bid}}
+	post := mblog.Post()
+	if post.ID != mid || post.MblogID != bid || post.Retweeted.ID != mid || post.Retweeted.MblogID != bid {
+		t.Errorf("Post() = %+v, retweeted %+v", post, post.Retweeted)
+	}
+	if mblog.MblogID != "" || mblog.Retweeted.ID != 0 {
+		t.Errorf("Post() modified the mblog: %+v, retweeted %+v", mblog, mblog.Retweeted)
+	}
+
+	cmblog := &CMblog{ID: "3501756485200075"}
+	if post := cmblog.Post(); post.ID != mid || post.MblogID != bid {
+		t.Errorf("CMblog.Post() = %+v", post)
+	}
+	if cmblog.MblogID != "" {
+		t.Errorf("Post() modified the mblog: %+v", cmblog)
+	}
+}
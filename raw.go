@@ -0,0 +1,416 @@
+package weibo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// 原始JSON的类型
+const (
+	PayloadPost    = "post"    // 网页端博文
+	PayloadCPost   = "cpost"   // 手机端博文
+	PayloadComment = "comment" // 评论，ParentID为博文id
+	PayloadUser    = "user"    // 用户，包括profile/detail
+)
+
+// Fetched 由接口解析得到的博文、评论和用户的原始JSON及来源
+type Fetched struct {
+	Endpoint string // 接口地址，不含参数，例如weibo.com/ajax/statuses/mymblog
+	At       time.Time
+	Raw      json.RawMessage
+}
+
+// recorder 由接口返回的完整JSON为其中的博文、评论和用户记录Fetched
+type recorder interface {
+	record(data []byte, endpoint string, at time.Time)
+}
+
+func (body *MymblogBody) record(data []byte, endpoint string, at time.Time) {
+	var raw struct {
+		Data struct {
+			List []json.RawMessage `json:"list"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(data, &raw) != nil || len(raw.Data.List) != len(body.Data.List) {
+		return
+	}
+	for i, m := range body.Data.List {
+		m.Fetched = &Fetched{Endpoint: endpoint, At: at, Raw: raw.Data.List[i]}
+	}
+}
+
+func (m *Mblog) record(data []byte, endpoint string, at time.Time) {
+	m.Fetched = &Fetched{Endpoint: endpoint, At: at, Raw: data}
+}
+
+func (body *CMblogBody) record(data []byte, endpoint string, at time.Time) {
+	type rawMblog struct {
+		Mblog json.RawMessage `json:"mblog"`
+	}
+	var raw struct {
+		Data struct {
+			Cards []struct {
+				rawMblog
+				CardGroup []rawMblog `json:"card_group"`
+			} `json:"cards"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(data, &raw) != nil || len(raw.Data.Cards) != len(body.Data.Cards) {
+		return
+	}
+	for i, card := range body.Data.Cards {
+		if r := raw.Data.Cards[i]; len(r.Mblog) > 0 {
+			card.Mblog.Fetched = &Fetched{Endpoint: endpoint, At: at, Raw: r.Mblog}
+		}
+		for j, group := range card.CardGroup {
+			if j < len(raw.Data.Cards[i].CardGroup) && len(raw.Data.Cards[i].CardGroup[j].Mblog) > 0 {
+				group.Mblog.Fetched = &Fetched{Endpoint: endpoint, At: at, Raw: raw.Data.Cards[i].CardGroup[j].Mblog}
+			}
+		}
+	}
+}
+
+func (body *CommentBody) record(data []byte, endpoint string, at time.Time) {
+	var raw struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(data, &raw) != nil || len(raw.Data) != len(body.Data) {
+		return
+	}
+	for i, comment := range body.Data {
+		comment.Fetched = &Fetched{Endpoint: endpoint, At: at, Raw: raw.Data[i]}
+	}
+}
+
+func (body *ProfileInfoBody) record(data []byte, endpoint string, at time.Time) {
+	var raw struct {
+		Data struct {
+			User json.RawMessage `json:"user"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(data, &raw) != nil || body.Data.User == nil {
+		return
+	}
+	body.Data.User.Fetched = &Fetched{Endpoint: endpoint, At: at, Raw: raw.Data.User}
+}
+
+func (body *ProfileDetailBody) record(data []byte, endpoint string, at time.Time) {
+	var raw struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(data, &raw) != nil || body.Data == nil {
+		return
+	}
+	body.Data.Fetched = &Fetched{Endpoint: endpoint, At: at, Raw: raw.Data}
+}
+
+// RawPayload 保存的一条原始JSON
+type RawPayload struct {
+	Kind      string
+	ID        int64
+	ParentID  int64
+	Endpoint  string
+	FetchedAt time.Time
+	Data      json.RawMessage
+}
+
+func (f *Fetched) payload(kind string, id int64, data json.RawMessage) *RawPayload {
+	return &RawPayload{Kind: kind, ID: id, Endpoint: f.Endpoint, FetchedAt: f.At, Data: data}
+}
+
+// Payload 返回博文的原始JSON，单独获取的长文本合并为LongTextRaw字段；
+// 不是由接口解析得到的博文返回nil
+func (m *Mblog) Payload() *RawPayload {
+	if m.Fetched == nil {
+		return nil
+	}
+	data := m.Fetched.Raw
+	if m.LongTextRaw != "" {
+		data = setJSON(data, m.LongTextRaw, "LongTextRaw")
+	}
+	if m.Retweeted != nil && m.Retweeted.LongTextRaw != "" {
+		data = setJSON(data, m.Retweeted.LongTextRaw, "retweeted_status", "LongTextRaw")
+	}
+	return m.Fetched.payload(PayloadPost, m.ID, data)
+}
+
+// Payload 同Mblog.Payload
+func (m *CMblog) Payload() *RawPayload {
+	if m.Fetched == nil {
+		return nil
+	}
+	id, err := m.Mid()
+	if err != nil {
+		return nil
+	}
+	data := m.Fetched.Raw
+	if m.LongTextRaw != "" {
+		data = setJSON(data, m.LongTextRaw, "LongTextRaw")
+	}
+	if m.Retweeted != nil && m.Retweeted.LongTextRaw != "" {
+		data = setJSON(data, m.Retweeted.LongTextRaw, "retweeted_status", "LongTextRaw")
+	}
+	return m.Fetched.payload(PayloadCPost, id, data)
+}
+
+// Payload 返回评论及其回复的原始JSON，mid为评论所属的博文id
+func (c *Comments) Payload(mid int64) *RawPayload {
+	if c.Fetched == nil {
+		return nil
+	}
+	payload := c.Fetched.payload(PayloadComment, c.Id, c.Fetched.Raw)
+	payload.ParentID = mid
+	return payload
+}
+
+// Payload 返回profile/info的原始JSON，profile/detail合并为detail字段
+func (u *User) Payload() *RawPayload {
+	if u.Fetched == nil {
+		return nil
+	}
+	data := u.Fetched.Raw
+	if u.Detail != nil && u.Detail.Fetched != nil {
+		data = setJSON(data, u.Detail.Fetched.Raw, "detail")
+	}
+	return u.Fetched.payload(PayloadUser, u.ID, data)
+}
+
+// setJSON 设置data中path处的字段，data不是对象时原样返回
+func setJSON(data json.RawMessage, value any, path ...string) json.RawMessage {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(data, &obj) != nil || obj == nil {
+		return data
+	}
+	if len(path) > 1 {
+		obj[path[0]] = setJSON(obj[path[0]], value, path[1:]...)
+	} else if v, err := marshalRaw(value); err == nil {
+		obj[path[0]] = v
+	} else {
+		return data
+	}
+	if v, err := marshalRaw(obj); err == nil {
+		return v
+	}
+	return data
+}
+
+// marshalRaw 同json.Marshal，但不转义HTML，正文中的链接保持原样
+func marshalRaw(v any) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// AddPayload 保存原始JSON，每次抓取保存一条，与上次抓取的内容相同时不保存；
+// 同一抓取时间已存在时更新（mysql的DATETIME只精确到秒），payload为nil时忽略
+func (database *Database) AddPayload(payload *RawPayload) error {
+	if payload == nil {
+		return nil
+	}
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+	if last, err := database.Payload(payload.Kind, payload.ID); err != nil {
+		return err
+	} else if last != nil && last.ParentID == payload.ParentID && bytes.Equal(last.Data, payload.Data) {
+		return nil
+	}
+
+	var text, compressed any
+	if database.Gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload.Data); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		compressed = buf.Bytes()
+	} else {
+		text = string(payload.Data)
+	}
+	_, err = db.Exec(db.dialect.upsert("raw_payload", []string{"Kind", "ID", "FetchedAt"}, []string{"ParentID", "Endpoint", "Payload", "Compressed"}),
+		payload.Kind, payload.ID, payload.FetchedAt, payload.ParentID, payload.Endpoint, text, compressed)
+	return err
+}
+
+// Payload 返回最近一次抓取的原始JSON，不存在时返回nil
+func (database *Database) Payload(kind string, id int64) (*RawPayload, error) {
+	payloads, err := database.payloads("WHERE Kind = ? AND ID = ? ORDER BY FetchedAt DESC LIMIT 1", kind, id)
+	if err != nil || len(payloads) == 0 {
+		return nil, err
+	}
+	return payloads[0], nil
+}
+
+// Payloads 按抓取时间顺序返回保存的全部原始JSON
+func (database *Database) Payloads(kind string, id int64) ([]*RawPayload, error) {
+	return database.payloads("WHERE Kind = ? AND ID = ? ORDER BY FetchedAt", kind, id)
+}
+
+func (database *Database) payloads(where string, args ...any) ([]*RawPayload, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT Kind, ID, ParentID, Endpoint, FetchedAt, Payload, Compressed FROM raw_payload "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payloads []*RawPayload
+	for rows.Next() {
+		payload := &RawPayload{}
+		var parent sql.NullInt64
+		var endpoint, text sql.NullString
+		var compressed []byte
+		if err := rows.Scan(&payload.Kind, &payload.ID, &parent, &endpoint, &payload.FetchedAt, &text, &compressed); err != nil {
+			return nil, err
+		}
+		payload.ParentID, payload.Endpoint = parent.Int64, endpoint.String
+		if len(compressed) > 0 {
+			r, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				return nil, err
+			}
+			if payload.Data, err = io.ReadAll(r); err != nil {
+				return nil, err
+			}
+		} else {
+			payload.Data = json.RawMessage(text.String)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, rows.Err()
+}
+
+// Reprocess 由保存的原始JSON重建博文、评论和用户等派生的表，不访问网络；
+// 每条博文、评论和用户使用最近一次抓取的JSON，同一博文的PC端和手机端JSON取较新的，
+// 抓取时间相同时取PC端；在一个事务中删除原有的图片、实体、实况照片和回复后重新写入，
+// 相对时间按抓取时间解析。progress在每条处理完成后回调，可以为nil
+func (database *Database) Reprocess(progress func(payload *RawPayload)) (int, error) {
+	n := 0
+	// 同一博文另一端的JSON更新时跳过
+	newer := map[string]string{
+		PayloadPost:  " AND NOT EXISTS (SELECT 1 FROM raw_payload o WHERE o.Kind = ? AND o.ID = raw_payload.ID AND o.FetchedAt > raw_payload.FetchedAt)",
+		PayloadCPost: " AND NOT EXISTS (SELECT 1 FROM raw_payload o WHERE o.Kind = ? AND o.ID = raw_payload.ID AND o.FetchedAt >= raw_payload.FetchedAt)",
+	}
+	other := map[string]string{PayloadPost: PayloadCPost, PayloadCPost: PayloadPost}
+	// 先处理用户，博文中不完整的用户只在不存在时插入
+	for _, kind := range []string{PayloadUser, PayloadPost, PayloadCPost, PayloadComment} {
+		var last int64
+		for {
+			// 分批读取，sqlite只有一个连接，不能边读边写
+			args := []any{kind, last}
+			if newer[kind] != "" {
+				args = append(args, other[kind])
+			}
+			payloads, err := database.payloads("WHERE Kind = ? AND ID > ? AND FetchedAt = "+
+				"(SELECT MAX(r.FetchedAt) FROM raw_payload r WHERE r.Kind = raw_payload.Kind AND r.ID = raw_payload.ID)"+newer[kind]+" ORDER BY ID LIMIT 200", args...)
+			if err != nil {
+				return n, err
+			}
+			if len(payloads) == 0 {
+				break
+			}
+			for _, payload := range payloads {
+				if err := database.transact(func(tx *sqlTx) error { return reprocess(tx, payload) }); err != nil {
+					return n, fmt.Errorf("reprocess %s %d: %w", payload.Kind, payload.ID, err)
+				}
+				n++
+				if progress != nil {
+					progress(payload)
+				}
+			}
+			last = payloads[len(payloads)-1].ID
+		}
+	}
+	return n, nil
+}
+
+func reprocess(tx *sqlTx, payload *RawPayload) error {
+	client := &Client{Clock: func() time.Time { return payload.FetchedAt }}
+	switch payload.Kind {
+	case PayloadPost:
+		mblog := &Mblog{}
+		if err := json.Unmarshal(payload.Data, mblog); err != nil {
+			return err
+		}
+		client.prepareMblog(mblog)
+		return rewritePost(tx, mblog.Post())
+	case PayloadCPost:
+		mblog := &CMblog{}
+		if err := json.Unmarshal(payload.Data, mblog); err != nil {
+			return err
+		}
+		client.prepareCMblog(mblog)
+		return rewritePost(tx, mblog.Post())
+	case PayloadComment:
+		comment := &Comments{}
+		if err := json.Unmarshal(payload.Data, comment); err != nil {
+			return err
+		}
+		client.prepareComments([]*Comments{comment})
+		// 删除原有的回复，已被删除的回复不再保留
+		if _, err := tx.Exec("DELETE FROM mblog_comment WHERE MblogID = ? AND (ID = ? OR RootID = ?)", payload.ParentID, comment.Id, comment.Id); err != nil {
+			return err
+		}
+		return writeComments(tx, tx.dialect, payload.ParentID, []*Comments{comment})
+	case PayloadUser:
+		user := &User{}
+		if err := json.Unmarshal(payload.Data, user); err != nil {
+			return err
+		}
+		return writeUser(tx, tx.dialect, user, payload.FetchedAt)
+	}
+	return fmt.Errorf("unknown payload kind %q", payload.Kind)
+}
+
+// rewritePost 重建博文及其图片、实体和实况照片。转发的博文单独抓取过时由其自身的
+// 原始JSON重建，这里不覆盖，否则按本条JSON重写
+func rewritePost(tx *sqlTx, post *Post) error {
+	posts := []*Post{post}
+	if re := post.Retweeted; re != nil {
+		var archived bool
+		err := tx.QueryRow("SELECT Archived FROM post WHERE ID = ?", re.ID).Scan(&archived)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		mode := saveRetweeted
+		if err == nil && !archived {
+			mode = saveRetweetedUpdate
+		}
+		if err := writePost(tx, tx.dialect, re, mode); err != nil {
+			return err
+		}
+		if !archived {
+			posts = append(posts, re)
+		}
+	}
+	if err := writePost(tx, tx.dialect, post, saveArchived); err != nil {
+		return err
+	}
+	for _, p := range posts {
+		if err := writeEntities(tx, p.ID, p.Entities); err != nil {
+			return err
+		}
+		if err := writeLivePhotos(tx, tx.dialect, p.ID, p.LivePhotos()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package weibo
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPayloadHistory(t *testing.T) {
+	database := newTestDatabase(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, ChinaTimeZone)
+	for i, data := range []string{`{"id":1,"v":1}`, `{"id":1,"v":1}`, `{"id":1,"v":2}`} {
+		payload := &RawPayload{Kind: PayloadPost, ID: 1, FetchedAt: at.Add(time.Duration(i) * time.Hour), Data: json.RawMessage(data)}
+		if err := database.AddPayload(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payloads, err := database.Payloads(PayloadPost, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内容相同的第二次抓取不保存
+	if len(payloads) != 2 || string(payloads[0].Data) != `{"id":1,"v":1}` || !payloads[1].FetchedAt.Equal(at.Add(2*time.Hour)) {
+		t.Errorf("Payloads = %+v", payloads)
+	}
+	if last, err := database.Payload(PayloadPost, 1); err != nil || last == nil || string(last.Data) != `{"id":1,"v":2}` {
+		t.Errorf("Payload = %+v, %v", last, err)
+	}
+}
+
+func TestReprocessReplacesDerivedRows(t *testing.T) {
+	database := newTestDatabase(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, ChinaTimeZone)
+
+	// 早期解析保存的内容：带图片和实体的博文，转发的博文也有图片，评论有一条回复
+	stale := &Post{ID: 1, MblogID: "a", Author: &User{ID: 10}, Text: "old", CreatedAt: at,
+		Pictures: []*PostMedia{{ID: "pid1", Type: "pic", URL: "https://wx1.sinaimg.cn/large/pid1.jpg"}},
+		Entities: []*Entity{{Type: "topic", Text: "#old#", Name: "old"}},
+		Retweeted: &Post{ID: 2, MblogID: "b", Author: &User{ID: 20}, Text: "old retweeted", CreatedAt: at,
+			Pictures: []*PostMedia{{ID: "pid2", Type: "pic", URL: "https://wx1.sinaimg.cn/large/pid2.jpg"}}}}
+	if err := database.AddPost(stale); err != nil {
+		t.Fatal(err)
+	}
+	root := &Comments{Id: 100, Rootid: 100, User: &User{ID: 30}, Text: "root", CreatedAt: "Wed May 01 12:00:00 +0800 2024",
+		Comments: []*Comments{{Id: 101, Rootid: 100, User: &User{ID: 31}, Text: "reply", CreatedAt: "Wed May 01 12:30:00 +0800 2024"}}}
+	if err := database.AddComments(1, []*Comments{root}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range []*RawPayload{
+		{Kind: PayloadPost, ID: 1, FetchedAt: at, Data: json.RawMessage(`{"id":1,"mblogid":"a","text_raw":"new","created_at":"Wed May 01 11:00:00 +0800 2024",` +
+			`"user":{"id":10,"screen_name":"author"},"retweeted_status":{"id":2,"mblogid":"b","text_raw":"new retweeted","created_at":"Wed May 01 10:00:00 +0800 2024","user":{"id":20}}}`)},
+		{Kind: PayloadComment, ID: 100, ParentID: 1, FetchedAt: at, Data: json.RawMessage(`{"id":100,"rootid":100,"text":"root","created_at":"Wed May 01 12:00:00 +0800 2024","user":{"id":30}}`)},
+	} {
+		if err := database.AddPayload(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := database.Reprocess(nil); err != nil || n != 2 {
+		t.Fatalf("Reprocess = %d, %v", n, err)
+	}
+
+	post, err := database.Post(1)
+	if err != nil || post == nil {
+		t.Fatalf("Post(1) = %v, %v", post, err)
+	}
+	if post.Text != "new" || len(post.Pictures) != 0 {
+		t.Errorf("Post(1) = %q with %d pictures, want new without pictures", post.Text, len(post.Pictures))
+	}
+	if entities, _ := database.Entities(1); len(entities) != 0 {
+		t.Errorf("Entities(1) = %+v, want none", entities)
+	}
+	if re := post.Retweeted; re == nil || re.Text != "new retweeted" || len(re.Pictures) != 0 {
+		t.Errorf("Retweeted = %+v, want rewritten from the payload", re)
+	}
+	comments, err := database.Comments(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].Id != 100 {
+		t.Errorf("Comments(1) = %d comments, want only the root", len(comments))
+	}
+}
+
+// 同一博文有PC端和手机端的JSON时取较新的，抓取时间相同时取PC端
+func TestReprocessNewestPayload(t *testing.T) {
+	database := newTestDatabase(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, ChinaTimeZone)
+	pc := func(id int64, fetchedAt time.Time) *RawPayload {
+		return &RawPayload{Kind: PayloadPost, ID: id, FetchedAt: fetchedAt,
+			Data: json.RawMessage(fmt.Sprintf(`{"id":%d,"mblogid":"m%d","text_raw":"pc","created_at":"Wed May 01 11:00:00 +0800 2024"}`, id, id))}
+	}
+	mobile := func(id int64, fetchedAt time.Time) *RawPayload {
+		return &RawPayload{Kind: PayloadCPost, ID: id, FetchedAt: fetchedAt,
+			Data: json.RawMessage(fmt.Sprintf(`{"id":"%d","bid":"m%d","text":"mobile","created_at":"Wed May 01 11:00:00 +0800 2024"}`, id, id))}
+	}
+	for _, payload := range []*RawPayload{
+		pc(1, at), mobile(1, at.Add(time.Hour)),
+		mobile(2, at), pc(2, at.Add(time.Hour)),
+		pc(3, at), mobile(3, at),
+	} {
+		if err := database.AddPayload(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := database.Reprocess(nil); err != nil || n != 3 {
+		t.Fatalf("Reprocess = %d, %v, want 3", n, err)
+	}
+	for id, want := range map[int64]string{1: "mobile", 2: "pc", 3: "pc"} {
+		if post, err := database.Post(id); err != nil || post == nil || post.Text != want {
+			t.Errorf("Post(%d) = %+v, %v, want text %s", id, post, err, want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package weibo
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type Format int
+
+const (
+	FormatPlain    Format = iota // 纯文本
+	FormatMarkdown               // Markdown
+	FormatHTML                   // 只保留链接、换行和表情的HTML
+)
+
+var (
+	emoticonRe = regexp.MustCompile(`\[[^\[\]\s]{1,12}\]`)
+	mdEscaper  = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`")
+	// Markdown链接地址中会截断或改变链接的字符
+	mdURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E", "\n", "%0A", "\r", "%0D")
+)
+
+// Renderer 将博文的HTML text或text_raw渲染为纯文本、Markdown或HTML，
+// 保留链接、@用户、话题和"全文"标记
+type Renderer struct {
+	Format     Format
+	EmojiImage bool              // 表情渲染为图片，默认渲染为[表情]文本
+	Emoticons  map[string]string // text_raw中[表情]到图片地址的映射，HTML中的表情自带图片
+
+	anchors []*anchor // 不为nil时记录渲染出的链接
+}
+
+// anchor HTML中的链接，start、end为链接文字在渲染结果中的字符偏移，
+// rendered为整个链接渲染结果的结束偏移，纯文本中普通链接后附有地址
+type anchor struct {
+	start, end, rendered  int
+	text, target, dataURL string
+}
+
+// RenderHTML 渲染博文的HTML text
+func (r *Renderer) RenderHTML(text string) string {
+	rendered, _ := r.renderHTML(text)
+	return rendered
+}
+
+// renderHTML 渲染HTML并返回其中的链接
+func (r *Renderer) renderHTML(text string) (string, []*anchor) {
+	nodes, err := nethtml.ParseFragment(strings.NewReader(text), &nethtml.Node{Type: nethtml.ElementNode, Data: "div", DataAtom: atom.Div})
+	if err != nil {
+		return r.text(text), nil
+	}
+	recorder := *r
+	recorder.anchors = []*anchor{}
+	var sb strings.Builder
+	for _, node := range nodes {
+		recorder.node(&sb, node)
+	}
+	// 去掉开头的空白后偏移随之前移
+	full := sb.String()
+	trimmed := strings.TrimSpace(full)
+	shift := utf8.RuneCountInString(full) - utf8.RuneCountInString(strings.TrimLeftFunc(full, unicode.IsSpace))
+	for _, a := range recorder.anchors {
+		a.start, a.end, a.rendered = a.start-shift, a.end-shift, a.rendered-shift
+	}
+	return trimmed, recorder.anchors
+}
+
+func (r *Renderer) node(sb *strings.Builder, node *nethtml.Node) {
+	switch node.Type {
+	case nethtml.TextNode:
+		sb.WriteString(r.emoticons(node.Data))
+		return
+	case nethtml.ElementNode:
+	default:
+		return
+	}
+
+	switch node.Data {
+	case "br":
+		sb.WriteString(r.newline())
+		return
+	case "img":
+		// 表情的alt为[表情]，链接前的图标没有alt
+		if alt := attr(node, "alt"); alt != "" {
+			sb.WriteString(r.emoticon(alt, attr(node, "src")))
+		}
+		return
+	case "a":
+		var inner strings.Builder
+		plain := &Renderer{Format: FormatPlain}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			plain.node(&inner, c)
+		}
+		text, target := strings.TrimSpace(inner.String()), linkTarget(node)
+		if r.anchors != nil {
+			start := utf8.RuneCountInString(sb.String())
+			sb.WriteString(r.link(text, target))
+			r.anchors = append(r.anchors, &anchor{start: start, end: start + utf8.RuneCountInString(text), rendered: utf8.RuneCountInString(sb.String()),
+				text: text, target: target, dataURL: attr(node, "data-url")})
+			return
+		}
+		sb.WriteString(r.link(text, target))
+		return
+	case "script", "style":
+		return
+	}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		r.node(sb, c)
+	}
+}
+
+// RenderRaw 渲染text_raw，urls用于展开t.cn短链接
+func (r *Renderer) RenderRaw(text string, urls []UrlStruct) string {
+	runes := []rune(text)
+	var sb strings.Builder
+	pos := 0
+	for _, e := range ExtractEntities(text, urls) {
+		if e.Start < pos {
+			continue
+		}
+		sb.WriteString(r.raw(string(runes[pos:e.Start])))
+		sb.WriteString(r.entity(e))
+		pos = e.End
+	}
+	sb.WriteString(r.raw(string(runes[pos:])))
+	return strings.TrimSpace(sb.String())
+}
+
+func (r *Renderer) raw(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = r.emoticons(line)
+	}
+	return strings.Join(lines, r.newline())
+}
+
+func (r *Renderer) entity(e *Entity) string {
+	switch e.Type {
+	case EntityMention:
+		return r.link(e.Text, "https://weibo.com/n/"+url.PathEscape(e.Name))
+	case EntityHashtag:
+		return r.link(e.Text, "https://s.weibo.com/weibo?q="+url.QueryEscape(e.Text))
+	case EntityURL, EntitySuperTopic:
+		target := e.LongURL
+		if target == "" {
+			target = e.URL
+		}
+		if target == "" {
+			return r.text(e.Text)
+		}
+		title := e.Title
+		if title == "" || e.Type == EntitySuperTopic {
+			title = e.Text
+		}
+		if title == e.URL {
+			title = target
+		}
+		return r.link(title, target)
+	}
+	return r.text(e.Text)
+}
+
+func (r *Renderer) link(text, target string) string {
+	target = safeURL(target)
+	if target == "" {
+		return r.text(text)
+	}
+	switch r.Format {
+	case FormatMarkdown:
+		if text == target {
+			return "<" + mdURLEscaper.Replace(target) + ">"
+		}
+		return "[" + r.text(text) + "](" + mdURLEscaper.Replace(target) + ")"
+	case FormatHTML:
+		return `<a href="` + html.EscapeString(target) + `">` + r.text(text) + "</a>"
+	}
+	// 纯文本中@用户和话题本身可读，普通链接附上地址
+	if strings.HasPrefix(text, "@") || strings.HasPrefix(text, "#") || text == target {
+		return text
+	}
+	return text + " (" + target + ")"
+}
+
+// emoticons 渲染文本中的[表情]
+func (r *Renderer) emoticons(text string) string {
+	var sb strings.Builder
+	pos := 0
+	for _, m := range emoticonRe.FindAllStringIndex(text, -1) {
+		sb.WriteString(r.text(text[pos:m[0]]))
+		alt := text[m[0]:m[1]]
+		sb.WriteString(r.emoticon(alt, r.Emoticons[alt]))
+		pos = m[1]
+	}
+	sb.WriteString(r.text(text[pos:]))
+	return sb.String()
+}
+
+func (r *Renderer) emoticon(alt, src string) string {
+	if !r.EmojiImage || src == "" {
+		return r.text(alt)
+	}
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	if src = safeURL(src); src == "" {
+		return r.text(alt)
+	}
+	switch r.Format {
+	case FormatMarkdown:
+		return "![" + r.text(alt) + "](" + mdURLEscaper.Replace(src) + ")"
+	case FormatHTML:
+		return `<img alt="` + html.EscapeString(alt) + `" src="` + html.EscapeString(src) + `">`
+	}
+	return alt
+}
+
+func (r *Renderer) text(text string) string {
+	switch r.Format {
+	case FormatMarkdown:
+		return mdEscaper.Replace(text)
+	case FormatHTML:
+		return html.EscapeString(text)
+	}
+	return text
+}
+
+func (r *Renderer) newline() string {
+	switch r.Format {
+	case FormatMarkdown:
+		return "  \n"
+	case FormatHTML:
+		return "<br>\n"
+	}
+	return "\n"
+}
+
+func attr(node *nethtml.Node, key string) string {
+	for _, a := range node.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// linkTarget 返回链接的真实地址，解开sinaurl跳转并补全相对地址
+func linkTarget(node *nethtml.Node) string {
+	href := attr(node, "href")
+	if u, err := url.Parse(href); err == nil && strings.HasSuffix(u.Path, "/sinaurl") && u.Query().Get("u") != "" {
+		return safeURL(u.Query().Get("u"))
+	}
+	if href == "" {
+		return safeURL(attr(node, "data-url"))
+	}
+	if strings.HasPrefix(href, "//") {
+		return "https:" + href
+	}
+	if strings.HasPrefix(href, "/status/") || strings.HasPrefix(href, "/detail/") || strings.HasPrefix(href, "/search") {
+		return "https://m.weibo.cn" + href
+	}
+	if strings.HasPrefix(href, "/") {
+		return "https://weibo.com" + href
+	}
+	return safeURL(href)
+}
+
+// safeURL 丢弃javascript:等非http链接
+func safeURL(s string) string {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || !strings.EqualFold(u.Scheme, "http") && !strings.EqualFold(u.Scheme, "https") {
+		return ""
+	}
+	return s
+}
+
+// Render 渲染博文正文
+func (m *Mblog) Render(r *Renderer) string {
+	return r.RenderRaw(m.TheText(), m.UrlStruct)
+}
+
+// Render 渲染手机端博文正文，已获取的长文本按text_raw渲染
+func (m *CMblog) Render(r *Renderer) string {
+	if m.LongTextRaw != "" {
+		return r.RenderRaw(m.LongTextRaw, nil)
+	}
+	return r.RenderHTML(m.Text)
+}
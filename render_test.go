@@ -0,0 +1,65 @@
+package weibo
+
+import "testing"
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		format Format
+		text   string
+		want   string
+	}{
+		{FormatPlain, `<a href="/n/小明">@小明</a> 你好<br>再见`, "@小明 你好\n再见"},
+		{FormatMarkdown, `<a href="/n/小明">@小明</a>`, "[@小明](https://weibo.com/n/小明)"},
+		{FormatHTML, `<a href="/n/小明">@小明</a>`, `<a href="https://weibo.com/n/小明">@小明</a>`},
+		{FormatPlain, `<a href="https://weibo.cn/sinaurl?u=https%3A%2F%2Fexample.com">网页链接</a>`, "网页链接 (https://example.com)"},
+		{FormatMarkdown, `<a href="https://example.com/a_(b) c<d>">链接</a>`, "[链接](https://example.com/a_%28b%29%20c%3Cd%3E)"},
+		{FormatMarkdown, `<a href="https://example.com/a>b">https://example.com/a>b</a>`, "<https://example.com/a%3Eb>"},
+		// 非http链接只保留文字
+		{FormatHTML, `<a href="https://weibo.cn/sinaurl?u=javascript:alert(1)">链接</a>`, "链接"},
+		{FormatHTML, `<a data-url="javascript:alert(2)">链接</a>`, "链接"},
+		{FormatMarkdown, `<a data-url="JavaScript:alert(3)">链接</a>`, "链接"},
+		{FormatHTML, `<a href="javascript:alert(4)">链接</a>`, "链接"},
+		{FormatHTML, `<a href="https://weibo.cn/sinaurl?u=data:text/html,x">链接</a>`, "链接"},
+		{FormatPlain, `<a data-url="javascript:alert(5)">链接</a>`, "链接"},
+	}
+	for _, tt := range tests {
+		if got := (&Renderer{Format: tt.format}).RenderHTML(tt.text); got != tt.want {
+			t.Errorf("RenderHTML(%d, %q) = %q, want %q", tt.format, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRenderEmoticon(t *testing.T) {
+	tests := []struct {
+		format Format
+		text   string
+		want   string
+	}{
+		{FormatHTML, `<img alt="[笑]" src="//face.t.sinajs.cn/x.png">`, `<img alt="[笑]" src="https://face.t.sinajs.cn/x.png">`},
+		{FormatMarkdown, `<img alt="[笑]" src="https://face.t.sinajs.cn/x (1).png">`, "![[笑]](https://face.t.sinajs.cn/x%20%281%29.png)"},
+		{FormatHTML, `<img alt="[笑]" src="javascript:alert(1)">`, "[笑]"},
+		{FormatMarkdown, `<img alt="[笑]" src="data:image/png;base64,AAAA">`, "[笑]"},
+	}
+	for _, tt := range tests {
+		if got := (&Renderer{Format: tt.format, EmojiImage: true}).RenderHTML(tt.text); got != tt.want {
+			t.Errorf("RenderHTML(%d, %q) = %q, want %q", tt.format, tt.text, got, tt.want)
+		}
+	}
+
+	r := &Renderer{Format: FormatHTML, EmojiImage: true, Emoticons: map[string]string{"[笑]": "javascript:alert(1)"}}
+	if got := r.RenderRaw("[笑]", nil); got != "[笑]" {
+		t.Errorf("RenderRaw = %q, want [笑]", got)
+	}
+}
+
+func TestRenderRawEntities(t *testing.T) {
+	urls := []UrlStruct{
+		{ShortUrl: "http://t.cn/A1", LongUrl: "https://example.com/a", UrlTitle: "网页链接"},
+		{ShortUrl: "http://t.cn/A2", LongUrl: "javascript:alert(1)", UrlTitle: "坏链接"},
+	}
+	got := (&Renderer{Format: FormatHTML}).RenderRaw("看 http://t.cn/A1 和 http://t.cn/A2", urls)
+	want := `看 <a href="https://example.com/a">网页链接</a> 和 坏链接`
+	if got != want {
+		t.Errorf("RenderRaw = %q, want %q", got, want)
+	}
+}
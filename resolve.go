@@ -0,0 +1,175 @@
+package weibo
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var digitsRe = regexp.MustCompile(`^\d+$`)
+
+// Ref 链接解析结果，用户链接只有UID，博文链接三者均有
+type Ref struct {
+	UID     string
+	ID      int64
+	MblogID string
+	Mblog   *Mblog // 解析时已请求接口获取的博文，没有请求时为nil
+}
+
+// IsMblog 是否指向一条博文
+func (r *Ref) IsMblog() bool {
+	return r.ID != 0 || r.MblogID != ""
+}
+
+// Resolve 将各种形式的用户或博文链接解析为Ref，支持：
+//   - 123、@名字、名字
+//   - weibo.com/u/123、weibo.com/123、weibo.com/n/名字、m.weibo.cn/profile/123
+//   - weibo.com/123/Abc12、m.weibo.cn/detail/4890...、m.weibo.cn/status/Abc12
+//   - t.cn短链接
+//
+// 昵称和缺失的博文字段通过接口查询补全
+func (c *Client) Resolve(link string) (*Ref, error) {
+	return c.resolve(link, 0)
+}
+
+// ResolveUID 将用户链接、昵称或博文链接解析为uid
+func (c *Client) ResolveUID(link string) (string, error) {
+	ref, err := c.Resolve(link)
+	if err != nil {
+		return "", err
+	}
+	return ref.UID, nil
+}
+
+func (c *Client) resolve(link string, depth int) (*Ref, error) {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return nil, fmt.Errorf("resolve: empty link")
+	}
+	if depth > 3 {
+		return nil, fmt.Errorf("resolve %s: too many redirects", link)
+	}
+	if digitsRe.MatchString(link) {
+		return &Ref{UID: link}, nil
+	}
+	if strings.HasPrefix(link, "@") {
+		return c.resolveName(link[1:])
+	}
+	if !strings.Contains(link, "/") && !strings.Contains(link, ".") {
+		return c.resolveName(link)
+	}
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	var parts []string
+	for _, p := range strings.Split(u.Path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	switch host {
+	case "t.cn":
+		location, err := c.expandShortUrl(u.String())
+		if err != nil {
+			return nil, err
+		}
+		return c.resolve(location, depth+1)
+	case "weibo.com", "m.weibo.cn", "weibo.cn", "m.weibo.com":
+	default:
+		return nil, fmt.Errorf("resolve %s: unsupported host", link)
+	}
+
+	switch {
+	case len(parts) >= 2 && (parts[0] == "u" || parts[0] == "profile") && digitsRe.MatchString(parts[1]):
+		return &Ref{UID: parts[1]}, nil
+	case len(parts) >= 2 && parts[0] == "n":
+		return c.resolveName(parts[1])
+	case len(parts) >= 2 && (parts[0] == "detail" || parts[0] == "status"):
+		return c.resolveMblog(&Ref{}, parts[1])
+	case len(parts) >= 2 && digitsRe.MatchString(parts[0]):
+		return c.resolveMblog(&Ref{UID: parts[0]}, parts[1])
+	case len(parts) == 1 && digitsRe.MatchString(parts[0]):
+		return &Ref{UID: parts[0]}, nil
+	case u.Query().Get("uid") != "":
+		return &Ref{UID: u.Query().Get("uid")}, nil
+	}
+	return nil, fmt.Errorf("resolve %s: unrecognized link", link)
+}
+
+// resolveName 按昵称查询uid，链接中的昵称已由url.Parse解码
+func (c *Client) resolveName(name string) (*Ref, error) {
+	user, err := c.GetUserByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", name, err)
+	}
+	return &Ref{UID: strconv.FormatInt(user.ID, 10)}, nil
+}
+
+// resolveMblog 根据数字id或mblogid补全博文的Ref，只有缺少uid时才请求接口
+func (c *Client) resolveMblog(ref *Ref, idOrBid string) (*Ref, error) {
+	var err error
+	if digitsRe.MatchString(idOrBid) {
+		if ref.ID, err = strconv.ParseInt(idOrBid, 10, 64); err != nil {
+			return nil, err
+		}
+		if ref.MblogID, err = MidToMblogID(ref.ID); err != nil {
+			return nil, err
+		}
+	} else {
+		ref.MblogID = idOrBid
+		if ref.ID, err = MblogIDToMid(idOrBid); err != nil {
+			return nil, err
+		}
+	}
+	if ref.UID != "" && ref.ID != 0 && ref.MblogID != "" {
+		return ref, nil
+	}
+
+	mblog, err := c.GetMblog(idOrBid)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", idOrBid, err)
+	}
+	ref.ID = mblog.ID
+	ref.MblogID = mblog.MblogID
+	ref.Mblog = mblog
+	if mblog.User != nil {
+		ref.UID = strconv.FormatInt(mblog.User.ID, 10)
+	}
+	return ref, nil
+}
+
+// expandShortUrl 返回t.cn短链接跳转的目标地址
+func (c *Client) expandShortUrl(shortUrl string) (string, error) {
+	client := c.httpClient()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	req, err := http.NewRequest("GET", shortUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:107.0) Gecko/20100101 Firefox/107.0")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	location := res.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("expand %s: no redirect, status %d", shortUrl, res.StatusCode)
+	}
+	return location, nil
+}
@@ -0,0 +1,88 @@
+package weibo
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	const mid, bid = 3501756485200075, "z0JH2lOMb"
+	var requests []string
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Host+r.URL.Path)
+		switch {
+		case r.Host == "t.cn" && r.URL.Path == "/A6abc":
+			http.Redirect(w, r, "https://weibo.com/u/5", http.StatusFound)
+		case r.Host == "t.cn" && r.URL.Path == "/A6post":
+			http.Redirect(w, r, "https://m.weibo.cn/status/"+bid, http.StatusFound)
+		case r.Host == "t.cn":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/ajax/profile/info":
+			if name := r.URL.Query().Get("screen_name"); name != "名字" {
+				t.Errorf("screen_name = %q", name)
+			}
+			w.Write([]byte(`{"ok":1,"data":{"user":{"id":7,"screen_name":"名字"}}}`))
+		case r.URL.Path == "/ajax/profile/detail":
+			w.Write([]byte(`{"ok":1,"data":{}}`))
+		case r.URL.Path == "/ajax/statuses/show":
+			if id := r.URL.Query().Get("id"); id != bid && id != fmt.Sprint(mid) {
+				t.Errorf("show id = %q", id)
+			}
+			fmt.Fprintf(w, `{"ok":1,"id":%d,"mblogid":%q,"user":{"id":8}}`, mid, bid)
+		default:
+			t.Errorf("unexpected request %s%s", r.Host, r.URL)
+		}
+	}))
+
+	tests := []struct {
+		link     string
+		uid      string
+		id       int64
+		mblogID  string
+		requests int // 需要请求接口的次数
+	}{
+		{"123", "123", 0, "", 0},
+		{" 123 ", "123", 0, "", 0},
+		{"@名字", "7", 0, "", 2},
+		{"名字", "7", 0, "", 2},
+		{"https://weibo.com/u/123", "123", 0, "", 0},
+		{"weibo.com/123?refer_flag=1001", "123", 0, "", 0},
+		{"https://www.weibo.com/n/%E5%90%8D%E5%AD%97", "7", 0, "", 2},
+		{"https://m.weibo.cn/profile/123", "123", 0, "", 0},
+		{"https://m.weibo.cn/u/123?uid=456", "123", 0, "", 0},
+		{"https://weibo.com/p/1005?uid=456", "456", 0, "", 0},
+		{"https://weibo.com/123/" + bid, "123", mid, bid, 0},
+		{fmt.Sprintf("https://weibo.com/123/%d", mid), "123", mid, bid, 0},
+		{fmt.Sprintf("https://m.weibo.cn/detail/%d", mid), "8", mid, bid, 1},
+		{"https://m.weibo.cn/status/" + bid, "8", mid, bid, 1},
+		{"https://t.cn/A6abc", "5", 0, "", 1},
+		{"http://t.cn/A6post", "8", mid, bid, 2},
+	}
+	for _, tt := range tests {
+		requests = nil
+		ref, err := client.Resolve(tt.link)
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.link, err)
+			continue
+		}
+		if ref.UID != tt.uid || ref.ID != tt.id || ref.MblogID != tt.mblogID {
+			t.Errorf("Resolve(%q) = %+v, want uid=%s id=%d mblogid=%s", tt.link, ref, tt.uid, tt.id, tt.mblogID)
+		}
+		if ref.IsMblog() != (tt.mblogID != "") {
+			t.Errorf("Resolve(%q).IsMblog() = %v", tt.link, ref.IsMblog())
+		}
+		if (ref.Mblog != nil) != (ref.IsMblog() && tt.requests > 0) {
+			t.Errorf("Resolve(%q).Mblog = %v", tt.link, ref.Mblog)
+		}
+		if len(requests) != tt.requests {
+			t.Errorf("Resolve(%q) requested %v, want %d requests", tt.link, requests, tt.requests)
+		}
+	}
+
+	for _, link := range []string{"", "https://example.com/u/123", "https://weibo.com/", "https://t.cn/missing", "https://weibo.com/123/z0JH2-OMb"} {
+		if ref, err := client.Resolve(link); err == nil {
+			t.Errorf("Resolve(%q) = %+v, want error", link, ref)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package weibo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Store S3兼容的对象存储，默认使用path-style地址，可直接对接MinIO等服务
+type S3Store struct {
+	Endpoint    string // 例如https://s3.amazonaws.com、http://127.0.0.1:9000
+	Region      string // 默认us-east-1
+	Bucket      string
+	AccessKey   string
+	SecretKey   string
+	Prefix      string // key的公共前缀
+	Layout      string // 默认DefaultLayout
+	VirtualHost bool   // 使用bucket.endpoint形式的地址
+	Client      *http.Client
+}
+
+func (s *S3Store) Key(k *MediaKey) string {
+	return path.Clean(RenderLayout(s.Layout, k))
+}
+
+func (s *S3Store) Exists(key string) (bool, error) {
+	res, err := s.do("HEAD", key, nil, nil, "")
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s.error(res, "HEAD", key)
+}
+
+func (s *S3Store) ModTime(key string) (time.Time, error) {
+	res, err := s.do("HEAD", key, nil, nil, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return time.Time{}, s.error(res, "HEAD", key)
+	}
+	return http.ParseTime(res.Header.Get("Last-Modified"))
+}
+
+func (s *S3Store) Put(key string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	res, err := s.do("PUT", key, nil, f, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return s.error(res, "PUT", key)
+	}
+	f.Close()
+	return os.Remove(file)
+}
+
+func (s *S3Store) Open(key string) (io.ReadCloser, error) {
+	res, err := s.do("GET", key, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, s.error(res, "GET", key)
+	}
+	return res.Body, nil
+}
+
+func (s *S3Store) Delete(key string) error {
+	res, err := s.do("DELETE", key, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s.error(res, "DELETE", key)
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.objectKey(prefix)}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		res, err := s.do("GET", "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			err = s.error(res, "LIST", prefix)
+			res.Body.Close()
+			return nil, err
+		}
+		result := &listBucketResult{}
+		err = xml.NewDecoder(res.Body).Decode(result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(strings.TrimPrefix(c.Key, s.Prefix), "/"))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) objectKey(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return strings.TrimSuffix(s.Prefix, "/") + "/" + key
+}
+
+func (s *S3Store) error(res *http.Response, op string, key string) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("s3 %s %s: status %d %s", op, key, res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// do 发送AWS Signature V4签名的请求，key为空时请求bucket本身
+func (s *S3Store) do(method string, key string, query url.Values, body io.Reader, payloadHash string) (*http.Response, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	host := endpoint.Host
+	uriPath := "/" + s.Bucket
+	if s.VirtualHost {
+		host = s.Bucket + "." + endpoint.Host
+		uriPath = ""
+	}
+	if key != "" {
+		uriPath += "/" + s.objectKey(key)
+	} else if uriPath == "" {
+		uriPath = "/"
+	}
+	canonicalURI := awsEscape(uriPath, false)
+	canonicalQuery := awsQuery(query)
+	rawUrl := endpoint.Scheme + "://" + host + canonicalURI
+	if canonicalQuery != "" {
+		rawUrl += "?" + canonicalQuery
+	}
+
+	req, err := http.NewRequest(method, rawUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := body.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			req.ContentLength = info.Size()
+		}
+		if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+	if payloadHash == "" {
+		payloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+	}
+	s.sign(req, canonicalURI, canonicalQuery, payloadHash, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// sign 为请求添加SigV4签名，签名的头部只有host、x-amz-content-sha256和x-amz-date
+func (s *S3Store) sign(req *http.Request, canonicalURI, canonicalQuery, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	req.Host = host
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, canonicalQuery, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape 按SigV4的要求编码，只保留unreserved字符
+func awsEscape(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func awsQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
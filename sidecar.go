@@ -0,0 +1,182 @@
+package weibo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 正文摘要的最大字数
+const excerptLength = 140
+
+// MediaMeta 图片的来源信息，保存为图片旁的json文件，也可写入XMP
+type MediaMeta struct {
+	ID         int64     `json:"id"`
+	MblogID    string    `json:"mblogid"`
+	UID        int64     `json:"uid"`
+	ScreenName string    `json:"screen_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	Text       string    `json:"text"` // 正文摘要
+	Pid        string    `json:"pid"`
+	URL        string    `json:"url"`  // 图片的原始地址
+	Link       string    `json:"link"` // 博文地址
+	Idx        int       `json:"idx"`  // 在博文中的序号，从0开始
+}
+
+func NewMediaMeta(post *Post, idx int, pid string, picUrl string) *MediaMeta {
+	meta := &MediaMeta{
+		ID:        post.ID,
+		MblogID:   post.MblogID,
+		UID:       post.UID(),
+		CreatedAt: post.CreatedAt,
+		Text:      excerpt(post.Text, excerptLength),
+		Pid:       pid,
+		URL:       picUrl,
+		Idx:       idx,
+	}
+	if post.Author != nil {
+		meta.ScreenName = post.Author.Name
+	}
+	if meta.UID > 0 && meta.MblogID != "" {
+		meta.Link = "https://weibo.com/" + strconv.FormatInt(meta.UID, 10) + "/" + meta.MblogID
+	}
+	return meta
+}
+
+func excerpt(text string, n int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n]) + "…"
+}
+
+// SidecarKey 图片对应的json文件的key
+func SidecarKey(key string) string {
+	return key + ".json"
+}
+
+// PutSidecar 将meta保存为key旁的json文件，staging为临时文件目录
+func PutSidecar(store MediaStore, key string, meta *MediaMeta, staging string) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := mkdir(staging); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(staging, filepath.Base(key)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return store.Put(SidecarKey(key), tmp.Name())
+}
+
+const xmpNamespace = "http://ns.adobe.com/xap/1.0/\x00"
+
+// XMP 返回包含作者、时间、正文和来源地址的XMP数据包
+func (meta *MediaMeta) XMP() []byte {
+	var b bytes.Buffer
+	esc := func(s string) string {
+		var sb strings.Builder
+		xml.EscapeText(&sb, []byte(s))
+		return sb.String()
+	}
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"` +
+		` xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/">`)
+	if meta.ScreenName != "" {
+		fmt.Fprintf(&b, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>", esc(meta.ScreenName))
+	}
+	if meta.Text != "" {
+		fmt.Fprintf(&b, `<dc:description><rdf:Alt><rdf:li xml:lang="x-default">%s</rdf:li></rdf:Alt></dc:description>`, esc(meta.Text))
+	}
+	if meta.MblogID != "" {
+		fmt.Fprintf(&b, "<dc:identifier>%s</dc:identifier>", esc(meta.MblogID))
+	}
+	if meta.Link != "" {
+		fmt.Fprintf(&b, "<dc:source>%s</dc:source><xmpRights:WebStatement>%s</xmpRights:WebStatement>", esc(meta.Link), esc(meta.Link))
+	} else if meta.URL != "" {
+		fmt.Fprintf(&b, "<dc:source>%s</dc:source>", esc(meta.URL))
+	}
+	if !meta.CreatedAt.IsZero() {
+		created := meta.CreatedAt.Format(time.RFC3339)
+		fmt.Fprintf(&b, "<xmp:CreateDate>%s</xmp:CreateDate><photoshop:DateCreated>%s</photoshop:DateCreated>", created, created)
+	}
+	b.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>\n")
+	b.WriteString(`<?xpacket end="w"?>`)
+	return b.Bytes()
+}
+
+// EmbedXMP 将meta以APP1段写入jpeg文件，替换已有的XMP，其他格式不处理
+func EmbedXMP(file string, meta *MediaMeta) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	packet := append([]byte(xmpNamespace), meta.XMP()...)
+	if len(packet)+2 > 0xFFFF {
+		return fmt.Errorf("xmp too large")
+	}
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(packet)+2))
+	segment = append(segment, packet...)
+
+	// 保留SOI之后的APP0(JFIF)和APP1(Exif)，XMP紧随其后
+	var out bytes.Buffer
+	out.Write(data[:2])
+	pos := 2
+	inserted := false
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker < 0xE0 || marker > 0xEF {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return fmt.Errorf("invalid jpeg segment")
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[pos+4:end], []byte(xmpNamespace)) {
+			// 已有的XMP
+			pos = end
+			continue
+		}
+		if marker != 0xE0 && marker != 0xE1 && !inserted {
+			out.Write(segment)
+			inserted = true
+		}
+		out.Write(data[pos:end])
+		pos = end
+	}
+	if !inserted {
+		out.Write(segment)
+	}
+	out.Write(data[pos:])
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,69 @@
+package weibo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Snapshot 博文在某一时刻的互动数
+type Snapshot struct {
+	ID             int64     `json:"id"`
+	MblogID        string    `json:"mblogid"`
+	At             time.Time `json:"at"`
+	RepostsCount   Count     `json:"reposts_count"`
+	CommentsCount  Count     `json:"comments_count"`
+	AttitudesCount Count     `json:"attitudes_count"`
+}
+
+func NewSnapshot(post *Post, at time.Time) *Snapshot {
+	return &Snapshot{
+		ID:             post.ID,
+		MblogID:        post.MblogID,
+		At:             at,
+		RepostsCount:   post.RepostsCount,
+		CommentsCount:  post.CommentsCount,
+		AttitudesCount: post.AttitudesCount,
+	}
+}
+
+// Tracker 定期重新获取近期博文，记录互动数的变化
+type Tracker struct {
+	Client   *Client
+	Database *Database
+	Recent   time.Duration // 跟踪发布时间在Recent以内的博文，默认7天
+	Sleep    time.Duration // 每条博文之间的间隔
+}
+
+// Poll 为每条近期博文记录一次快照，单条博文获取失败（如已删除）不影响其他博文，
+// 这些错误合并后返回；cookie失效时立即停止
+func (t *Tracker) Poll() (int, error) {
+	recent := t.Recent
+	if recent <= 0 {
+		recent = 7 * 24 * time.Hour
+	}
+	mblogIDs, err := t.Database.RecentMblogIDs(t.Client.now().Add(-recent))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	var errs []error
+	for _, mblogID := range mblogIDs {
+		mblog, err := t.Client.GetMblog(mblogID)
+		if errors.Is(err, Unauthorized) {
+			return n, errors.Join(append(errs, fmt.Errorf("snapshot %s: %w", mblogID, err))...)
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("snapshot %s: %w", mblogID, err))
+		} else {
+			if err := t.Database.AddSnapshot(NewSnapshot(mblog.Post(), t.Client.now())); err != nil {
+				return n, errors.Join(append(errs, err)...)
+			}
+			n++
+		}
+		if t.Sleep > 0 {
+			time.Sleep(t.Sleep)
+		}
+	}
+	return n, errors.Join(errs...)
+}
@@ -0,0 +1,32 @@
+package weibo
+
+import (
+	"testing"
+	"time"
+)
+
+// 同一秒内的快照在mysql的DATETIME中主键相同，只保留最后一次
+func TestAddSnapshotSameSecond(t *testing.T) {
+	database := newTestDatabase(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 100, ChinaTimeZone)
+	for i, at := range []time.Time{at, at.Add(500 * time.Millisecond), at.Add(time.Second)} {
+		snapshot := &Snapshot{ID: 1, MblogID: "a", At: at, RepostsCount: Count(i), CommentsCount: Count(10 * i), AttitudesCount: Count(100 * i)}
+		if err := database.AddSnapshot(snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := database.Snapshots(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("Snapshots = %d, want 2", len(snapshots))
+	}
+	if s := snapshots[0]; !s.At.Equal(at.Truncate(time.Second)) || s.RepostsCount != 1 || s.CommentsCount != 10 || s.AttitudesCount != 100 {
+		t.Errorf("first snapshot = %+v, want the later one of the same second", s)
+	}
+	if s := snapshots[1]; !s.At.Equal(at.Truncate(time.Second).Add(time.Second)) || s.RepostsCount != 2 {
+		t.Errorf("second snapshot = %+v", s)
+	}
+}
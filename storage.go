@@ -0,0 +1,716 @@
+package weibo
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store 博文、用户、评论和媒体文件的存储，Database为SQL实现，MemoryStore为内存实现
+type Store interface {
+	Migrate() error
+	Close()
+
+	HasPost(post *Post) (bool, error)
+	AddPost(post *Post) error
+	UpdatePost(post *Post) error
+	Post(id int64) (*Post, error) // 不存在时返回nil
+	Posts(query *PostQuery) ([]*Post, error)
+
+	HasUser(id int64) (bool, error)
+	AddUser(user *User) error // 已存在时更新
+	User(id int64) (*User, error)
+
+	AddComments(id int64, comments []*Comments) error // id为博文id，回复一并保存
+	Comments(id int64) ([]*Comments, error)
+
+	AddMedia(media []*Media) error // 同一博文同一序号的文件已存在时更新
+	Media(id int64) ([]*Media, error)
+}
+
+var (
+	_ Store = (*Database)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// PostQuery 查询博文的条件，零值表示不限制，结果按发布时间从新到旧排列
+type PostQuery struct {
+	UID   int64
+	Text  string // 正文包含的文字
+	Since time.Time
+	Until time.Time // 不包含
+	Limit int
+}
+
+func (q *PostQuery) match(post *Post) bool {
+	if q == nil {
+		return true
+	}
+	if q.UID != 0 && post.UID() != q.UID {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(post.Text, q.Text) {
+		return false
+	}
+	if !q.Since.IsZero() && post.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !post.CreatedAt.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Media 已保存的媒体文件
+type Media struct {
+	ID     int64  // 博文id
+	Kind   string // pic、motion、video
+	Idx    int
+	Pid    string // pid或视频id
+	URL    string
+	Key    string // 在媒体存储中的key
+	Size   int64
+	SHA256 string
+}
+
+// Media 返回下载成功的文件，用于Store.AddMedia
+func (r *DownloadReport) Media() []*Media {
+	var media []*Media
+	for _, result := range r.Results {
+		job := result.Job
+		if result.Err != nil || job.Key == nil || job.Key.ID == 0 {
+			continue
+		}
+		media = append(media, &Media{ID: job.Key.ID, Kind: job.Kind, Idx: job.Key.Idx, Pid: job.Name, URL: job.URL,
+			Key: result.File, Size: result.Size, SHA256: result.SHA256})
+	}
+	return media
+}
+
+// dialect 处理不同数据库的占位符、upsert和类型差异
+type dialect struct {
+	name     string
+	numbered bool              // 占位符为$1、$2
+	types    *strings.Replacer // 建表语句中的{datetime}等类型
+}
+
+var (
+	mysqlDialect = &dialect{name: "mysql", types: strings.NewReplacer(
+		"{datetime}", "DATETIME",
+		"{bool}", "BOOLEAN",
+		"{json}", "TEXT",
+		"{longjson}", "LONGTEXT",
+		"{blob}", "LONGBLOB",
+	)}
+	sqliteDialect = &dialect{name: "sqlite", types: strings.NewReplacer(
+		"{datetime}", "DATETIME",
+		"{bool}", "BOOLEAN",
+		"{json}", "TEXT",
+		"{longjson}", "TEXT",
+		"{blob}", "BLOB",
+	)}
+	postgresDialect = &dialect{name: "postgres", numbered: true, types: strings.NewReplacer(
+		"{datetime}", "TIMESTAMPTZ",
+		"{bool}", "BOOLEAN",
+		"{json}", "JSONB",
+		"{longjson}", "JSONB",
+		"{blob}", "BYTEA",
+	)}
+)
+
+// dialectOf 按驱动名选择方言，未知的驱动按mysql处理
+func dialectOf(dn string) *dialect {
+	switch dn {
+	case "sqlite", "sqlite3":
+		return sqliteDialect
+	case "postgres", "pgx":
+		return postgresDialect
+	}
+	return mysqlDialect
+}
+
+// rebind 将?占位符改写为方言的占位符，跳过引号中的?
+func (d *dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// args 转换参数中的时间：sqlite以文本保存时间，按文本比较和排序，
+// 不同时区的时间统一转换为UTC，没有名称的时区（如"+0800 +0800"）也无法再读取
+func (d *dialect) args(args []any) []any {
+	if d != sqliteDialect {
+		return args
+	}
+	var converted []any
+	for i, arg := range args {
+		var t any
+		switch v := arg.(type) {
+		case time.Time:
+			t = v.UTC()
+		case sql.NullTime:
+			t = sql.NullTime{Time: v.Time.UTC(), Valid: v.Valid}
+		default:
+			continue
+		}
+		if converted == nil {
+			converted = append([]any{}, args...)
+		}
+		converted[i] = t
+	}
+	if converted == nil {
+		return args
+	}
+	return converted
+}
+
+// match 返回全文检索的条件和参数，postgres使用GIN索引，其他数据库使用LIKE
+func (d *dialect) match(col string, text string) (string, any) {
+	if d == postgresDialect {
+		return "to_tsvector('simple', COALESCE(" + col + ", '')) @@ plainto_tsquery('simple', ?)", text
+	}
+	return col + " LIKE ?", "%" + text + "%"
+}
+
+func (d *dialect) ddl(stmt string) string {
+	return d.types.Replace(stmt)
+}
+
+func insert(table string, keys []string, cols []string) string {
+	all := append(append([]string{}, keys...), cols...)
+	return "INSERT INTO " + table + "(" + strings.Join(all, ", ") + ") VALUES(" + strings.TrimSuffix(strings.Repeat("?,", len(all)), ",") + ")"
+}
+
+// insertIgnore 返回插入语句，keys冲突时不做任何修改
+func (d *dialect) insertIgnore(table string, keys []string, cols []string) string {
+	if d == mysqlDialect {
+		return strings.Replace(insert(table, keys, cols), "INSERT", "INSERT IGNORE", 1)
+	}
+	return insert(table, keys, cols) + " ON CONFLICT(" + strings.Join(keys, ", ") + ") DO NOTHING"
+}
+
+// upsert 返回插入语句，keys冲突时更新其余字段
+func (d *dialect) upsert(table string, keys []string, cols []string) string {
+	if len(cols) == 0 {
+		return d.insertIgnore(table, keys, cols)
+	}
+	insert := insert(table, keys, cols)
+	var sets []string
+	if d == mysqlDialect {
+		for _, col := range cols {
+			sets = append(sets, col+" = VALUES("+col+")")
+		}
+		return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+	for _, col := range cols {
+		sets = append(sets, col+" = excluded."+col)
+	}
+	return insert + " ON CONFLICT(" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// sqlDB 按方言改写语句的*sql.DB
+type sqlDB struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (db *sqlDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *sqlDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *sqlDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+// sqlTx 按方言改写语句的*sql.Tx
+type sqlTx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (tx *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), tx.dialect.args(args)...)
+}
+
+func (tx *sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), tx.dialect.args(args)...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), tx.dialect.args(args)...)
+}
+
+// sqlExecer sqlDB或sqlTx，sqlite只有一个连接，事务中的读写都需要通过事务进行
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// transact 在事务中执行fn，fn返回错误时回滚
+func (database *Database) transact(fn func(tx *sqlTx) error) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(&sqlTx{Tx: tx, dialect: db.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
+
+// writePost的保存方式
+const (
+	saveArchived        = iota // 抓取到的博文，已存在时更新
+	saveRetweeted              // 只是被转发的博文，已存在时不覆盖
+	saveRetweetedUpdate        // 只是被转发的博文，已存在时更新，不改变Archived
+)
+
+// writePost 按mode保存博文、作者和图片，不包括转发的博文
+func writePost(x sqlExecer, d *dialect, post *Post, mode int) error {
+	if post.Author != nil && post.Author.ID > 0 {
+		if _, err := x.Exec(d.insertIgnore("weibo_user", []string{"ID"}, []string{"ScreenName", "Avatar"}),
+			post.Author.ID, post.Author.Name, post.Author.Icon); err != nil {
+			return err
+		}
+	}
+
+	var retweetedID int64
+	if post.Retweeted != nil {
+		retweetedID = post.Retweeted.ID
+	}
+	cols := []string{"MblogID", "UID", "Text", "IsLongText", "CreatedAt", "Source", "Region", "RetweetedID",
+		"RepostsCount", "CommentsCount", "AttitudesCount", "UpdatedAt"}
+	args := []any{post.ID, post.MblogID, nullID(post.UID()), post.Text, post.IsLongText, nullTime(post.CreatedAt), post.Source, post.Region,
+		nullID(retweetedID), int64(post.RepostsCount), int64(post.CommentsCount), int64(post.AttitudesCount), time.Now()}
+	var stmt string
+	switch mode {
+	case saveArchived:
+		stmt = d.upsert("post", []string{"ID"}, append(cols, "Archived"))
+		args = append(args, true)
+	case saveRetweeted:
+		stmt = d.insertIgnore("post", []string{"ID"}, cols)
+	default:
+		stmt = d.upsert("post", []string{"ID"}, cols)
+	}
+	result, err := x.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 && mode == saveRetweeted {
+		// 被转发的博文已存在
+		return nil
+	}
+
+	if _, err := x.Exec("DELETE FROM post_media WHERE PostID = ?", post.ID); err != nil {
+		return err
+	}
+	for i, pic := range post.Pictures {
+		if _, err := x.Exec(d.upsert("media", []string{"Pid"}, []string{"Type", "URL", "VideoURL"}),
+			pic.ID, pic.Type, pic.URL, pic.VideoURL); err != nil {
+			return err
+		}
+		if _, err := x.Exec("INSERT INTO post_media(PostID, Idx, Pid) VALUES(?,?,?)", post.ID, i, pic.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const postColumns = "p.ID, p.MblogID, p.UID, u.ScreenName, p.Text, p.IsLongText, p.CreatedAt, p.Source, p.Region, p.RetweetedID, " +
+	"p.RepostsCount, p.CommentsCount, p.AttitudesCount FROM post p LEFT JOIN weibo_user u ON u.ID = p.UID"
+
+// readPosts 查询博文及其图片和转发的博文，不包括实体
+func readPosts(x sqlExecer, query string, args ...any) ([]*Post, error) {
+	rows, err := x.Query("SELECT "+postColumns+" "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var posts []*Post
+	var retweetedIDs []int64
+	for rows.Next() {
+		post := &Post{}
+		var uid, retweetedID sql.NullInt64
+		var name, text, source, region sql.NullString
+		var createdAt sql.NullTime
+		var reposts, comments, attitudes sql.NullInt64
+		if err := rows.Scan(&post.ID, &post.MblogID, &uid, &name, &text, &post.IsLongText, &createdAt, &source, &region,
+			&retweetedID, &reposts, &comments, &attitudes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if uid.Valid {
+			post.Author = &User{ID: uid.Int64, Name: name.String}
+		}
+		post.Text, post.CreatedAt, post.Source, post.Region = text.String, createdAt.Time, source.String, region.String
+		post.RepostsCount, post.CommentsCount, post.AttitudesCount = Count(reposts.Int64), Count(comments.Int64), Count(attitudes.Int64)
+		posts = append(posts, post)
+		retweetedIDs = append(retweetedIDs, retweetedID.Int64)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, post := range posts {
+		if post.Pictures, err = readPictures(x, post.ID); err != nil {
+			return nil, err
+		}
+		if retweetedIDs[i] == 0 {
+			continue
+		}
+		retweeted, err := readPosts(x, "WHERE p.ID = ?", retweetedIDs[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(retweeted) > 0 {
+			post.Retweeted = retweeted[0]
+		}
+	}
+	return posts, nil
+}
+
+func readPictures(x sqlExecer, id int64) ([]*PostMedia, error) {
+	rows, err := x.Query("SELECT m.Pid, m.Type, m.URL, m.VideoURL FROM post_media pm JOIN media m ON m.Pid = pm.Pid WHERE pm.PostID = ? ORDER BY pm.Idx", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pics []*PostMedia
+	for rows.Next() {
+		pic := &PostMedia{}
+		var picType, picUrl, videoUrl sql.NullString
+		if err := rows.Scan(&pic.ID, &picType, &picUrl, &videoUrl); err != nil {
+			return nil, err
+		}
+		pic.Type, pic.URL, pic.VideoURL = picType.String, picUrl.String, videoUrl.String
+		pics = append(pics, pic)
+	}
+	return pics, rows.Err()
+}
+
+// UpdatePost 与AddPost相同，已存在的博文会被更新
+func (database *Database) UpdatePost(post *Post) error {
+	return database.AddPost(post)
+}
+
+func (database *Database) queryPosts(query string, args ...any) ([]*Post, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := readPosts(db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, post := range posts {
+		for p := post; p != nil; p = p.Retweeted {
+			if p.Entities, err = database.Entities(p.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return posts, nil
+}
+
+// Post 返回博文，包括只作为转发保存的博文
+func (database *Database) Post(id int64) (*Post, error) {
+	posts, err := database.queryPosts("WHERE p.ID = ?", id)
+	if err != nil || len(posts) == 0 {
+		return nil, err
+	}
+	return posts[0], nil
+}
+
+// Posts 查询抓取到的博文，不包括只作为转发保存的博文
+func (database *Database) Posts(query *PostQuery) ([]*Post, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"p.Archived = ?"}
+	args := []any{true}
+	if query == nil {
+		query = &PostQuery{}
+	}
+	if query.UID != 0 {
+		where, args = append(where, "p.UID = ?"), append(args, query.UID)
+	}
+	if query.Text != "" {
+		cond, arg := db.dialect.match("p.Text", query.Text)
+		where, args = append(where, cond), append(args, arg)
+	}
+	if !query.Since.IsZero() {
+		where, args = append(where, "p.CreatedAt >= ?"), append(args, query.Since)
+	}
+	if !query.Until.IsZero() {
+		where, args = append(where, "p.CreatedAt < ?"), append(args, query.Until)
+	}
+	stmt := "WHERE " + strings.Join(where, " AND ") + " ORDER BY p.CreatedAt DESC"
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	return database.queryPosts(stmt, args...)
+}
+
+func (database *Database) HasUser(id int64) (bool, error) {
+	user, err := database.User(id)
+	return user != nil, err
+}
+
+var userColumns = []string{"ScreenName", "Avatar", "Description", "Gender", "Location",
+	"FollowersCount", "FriendsCount", "StatusesCount", "Verified", "VerifiedType", "VerifiedReason"}
+
+func userValues(user *User) []any {
+	return []any{user.Name, user.Icon, user.Description, user.Gender, user.Location,
+		user.FollowersCount, user.FriendsCount, user.StatusesCount, user.Verified, user.VerifiedType, user.VerifiedReason}
+}
+
+func scanUser(scan func(dest ...any) error, user *User, extra ...any) error {
+	var name, avatar, description, gender, location, reason sql.NullString
+	var followers, friends, statuses sql.NullInt64
+	var verified sql.NullBool
+	var verifiedType sql.NullInt64
+	dest := append([]any{&user.ID, &name, &avatar, &description, &gender, &location, &followers, &friends, &statuses,
+		&verified, &verifiedType, &reason}, extra...)
+	if err := scan(dest...); err != nil {
+		return err
+	}
+	user.Name, user.Icon, user.Description, user.Gender, user.Location = name.String, avatar.String, description.String, gender.String, location.String
+	user.FollowersCount, user.FriendsCount, user.StatusesCount = Count(followers.Int64), Count(friends.Int64), Count(statuses.Int64)
+	user.Verified, user.VerifiedType, user.VerifiedReason = verified.Bool, int(verifiedType.Int64), reason.String
+	return nil
+}
+
+// AddUser 保存用户资料，资料有变化时在user_profile中记录一条历史，
+// 由接口获取的用户同时保存原始JSON
+func (database *Database) AddUser(user *User) error {
+	if err := database.addUser(user, time.Now()); err != nil {
+		return err
+	}
+	return database.AddPayload(user.Payload())
+}
+
+// addUser 以at作为资料的更新时间保存用户
+func (database *Database) addUser(user *User, at time.Time) error {
+	return database.transact(func(tx *sqlTx) error {
+		return writeUser(tx, tx.dialect, user, at)
+	})
+}
+
+func writeUser(x sqlExecer, d *dialect, user *User, at time.Time) error {
+	old, err := readUser(x, user.ID)
+	if err != nil {
+		return err
+	}
+	if old == nil || !reflect.DeepEqual(userValues(old), userValues(user)) {
+		if _, err := x.Exec(d.insertIgnore("user_profile", []string{"UID", "At"}, userColumns), append([]any{user.ID, at}, userValues(user)...)...); err != nil {
+			return err
+		}
+	}
+	_, err = x.Exec(d.upsert("weibo_user", []string{"ID"}, append(userColumns, "UpdatedAt")), append(append([]any{user.ID}, userValues(user)...), at)...)
+	return err
+}
+
+func (database *Database) User(id int64) (*User, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+	return readUser(db, id)
+}
+
+func readUser(x sqlExecer, id int64) (*User, error) {
+	user := &User{}
+	err := scanUser(x.QueryRow("SELECT ID, "+strings.Join(userColumns, ", ")+" FROM weibo_user WHERE ID = ?", id).Scan, user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UserProfile 用户资料的一次变化
+type UserProfile struct {
+	At   time.Time
+	User *User
+}
+
+// UserProfiles 按时间顺序返回用户资料的变化历史
+func (database *Database) UserProfiles(id int64) ([]*UserProfile, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT UID, "+strings.Join(userColumns, ", ")+", At FROM user_profile WHERE UID = ? ORDER BY At", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*UserProfile
+	for rows.Next() {
+		profile := &UserProfile{User: &User{}}
+		if err := scanUser(rows.Scan, profile.User, &profile.At); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+// AddComments 保存博文的评论，由接口获取的评论同时保存原始JSON
+func (database *Database) AddComments(id int64, comments []*Comments) error {
+	if err := database.addComments(id, comments); err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		if err := database.AddPayload(comment.Payload(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (database *Database) addComments(id int64, comments []*Comments) error {
+	return database.transact(func(tx *sqlTx) error {
+		return writeComments(tx, tx.dialect, id, comments)
+	})
+}
+
+func writeComments(x sqlExecer, d *dialect, id int64, comments []*Comments) error {
+	stmt := d.upsert("mblog_comment", []string{"ID"}, []string{"MblogID", "RootID", "UID", "ScreenName", "Text", "TextRaw", "Source", "LikeCounts", "CreatedAt"})
+	for _, comment := range flattenComments(comments) {
+		var uid int64
+		var name string
+		if comment.User != nil {
+			uid, name = comment.User.ID, comment.User.Name
+		}
+		createdAt := createdTime(comment.CreatedTime, comment.CreatedAt, comment.Fetched)
+		if _, err := x.Exec(stmt, comment.Id, id, comment.Rootid, uid, name, comment.Text, comment.TextRaw, comment.Source,
+			comment.LikeCounts, nullTime(createdAt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flattenComments 展开评论的楼中楼回复
+func flattenComments(comments []*Comments) []*Comments {
+	var all []*Comments
+	for _, comment := range comments {
+		all = append(all, comment)
+		all = append(all, flattenComments(comment.Comments)...)
+	}
+	return all
+}
+
+// Comments 按时间顺序返回博文的全部评论，回复不再嵌套
+func (database *Database) Comments(id int64) ([]*Comments, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT ID, RootID, UID, ScreenName, Text, TextRaw, Source, LikeCounts, CreatedAt FROM mblog_comment WHERE MblogID = ? ORDER BY CreatedAt, ID", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comments
+	for rows.Next() {
+		comment := &Comments{User: &User{}}
+		var name, text, textRaw, source sql.NullString
+		var likes sql.NullInt64
+		var createdAt sql.NullTime
+		if err := rows.Scan(&comment.Id, &comment.Rootid, &comment.User.ID, &name, &text, &textRaw, &source, &likes, &createdAt); err != nil {
+			return nil, err
+		}
+		comment.User.Name, comment.Text, comment.TextRaw, comment.Source = name.String, text.String, textRaw.String, source.String
+		comment.LikeCounts = int(likes.Int64)
+		if createdAt.Valid {
+			comment.CreatedAt = createdAt.Time.In(ChinaTimeZone).Format(TimeLayout)
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+func (database *Database) AddMedia(media []*Media) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
+	}
+
+	stmt := db.dialect.upsert("media_file", []string{"ID", "Kind", "Idx"}, []string{"Pid", "URL", "MediaKey", "Size", "SHA256"})
+	for _, m := range media {
+		if _, err := db.Exec(stmt, m.ID, m.Kind, m.Idx, m.Pid, m.URL, m.Key, m.Size, m.SHA256); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (database *Database) Media(id int64) ([]*Media, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT ID, Kind, Idx, Pid, URL, MediaKey, Size, SHA256 FROM media_file WHERE ID = ? ORDER BY Kind, Idx", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var media []*Media
+	for rows.Next() {
+		m := &Media{}
+		var url, key, sha sql.NullString
+		var size sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.Kind, &m.Idx, &m.Pid, &url, &key, &size, &sha); err != nil {
+			return nil, err
+		}
+		m.URL, m.Key, m.Size, m.SHA256 = url.String, key.String, size.Int64, sha.String
+		media = append(media, m)
+	}
+	return media, rows.Err()
+}
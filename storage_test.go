@@ -0,0 +1,318 @@
+package weibo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, &MemoryStore{})
+}
+
+func TestDatabaseStore(t *testing.T) {
+	database := newTestDatabase(t)
+	testStore(t, database)
+
+	// 只有已归档的博文，跨时区比较
+	since := time.Date(2024, 5, 1, 11, 0, 0, 0, time.FixedZone("", 8*3600))
+	ids, err := database.RecentMblogIDs(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ids, ","); got != "c,b" {
+		t.Errorf("RecentMblogIDs = %s, want c,b", got)
+	}
+}
+
+func mustParse(t *testing.T, layout, value string) time.Time {
+	t.Helper()
+	tm, err := time.Parse(layout, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+// testStore 检查Store的实现，博文的发布时间使用不同的时区，
+// 按文本比较时顺序与实际相反；postgres的全文检索按词匹配，检索的文字都是完整的词
+func testStore(t *testing.T, store Store) {
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	author := &User{ID: 10, Name: "author"}
+	retweeted := &Post{ID: 4, MblogID: "d", Text: "retweeted", CreatedAt: mustParse(t, time.RubyDate, "Tue Apr 30 08:00:00 +0800 2024")}
+	posts := []*Post{
+		{ID: 1, MblogID: "a", Author: author, Text: "first", CreatedAt: mustParse(t, time.RubyDate, "Wed May 01 10:00:00 +0800 2024"),
+			Pictures: []*PostMedia{{ID: "pid1", Type: "pic", URL: "https://wx1.sinaimg.cn/large/pid1.jpg"}}},
+		{ID: 2, MblogID: "b", Author: author, Text: "second", CreatedAt: time.Date(2024, 5, 1, 3, 30, 0, 0, time.UTC), Retweeted: retweeted},
+		{ID: 3, MblogID: "c", Author: author, Text: "third", CreatedAt: mustParse(t, time.RFC3339, "2024-05-01T09:00:00-07:00")},
+	}
+	for _, post := range posts {
+		if err := store.AddPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post, err := store.Post(2)
+	if err != nil || post == nil {
+		t.Fatalf("Post(2) = %v, %v", post, err)
+	}
+	if post.Text != "second" || post.UID() != 10 || !post.CreatedAt.Equal(posts[1].CreatedAt) {
+		t.Errorf("Post(2) = %+v", post)
+	}
+	if post.Retweeted == nil || post.Retweeted.ID != 4 || !post.Retweeted.CreatedAt.Equal(retweeted.CreatedAt) {
+		t.Errorf("Post(2).Retweeted = %+v", post.Retweeted)
+	}
+	if post, _ := store.Post(1); post == nil || len(post.Pictures) != 1 || post.Pictures[0].URL != posts[0].Pictures[0].URL {
+		t.Errorf("Post(1).Pictures = %+v", post)
+	}
+	if ok, err := store.HasPost(posts[2]); err != nil || !ok {
+		t.Errorf("HasPost = %v, %v", ok, err)
+	}
+	if post, err := store.Post(100); err != nil || post != nil {
+		t.Errorf("Post(missing) = %v, %v", post, err)
+	}
+	// 只作为转发保存的博文可以读取，但不算已抓取
+	if post, err := store.Post(4); err != nil || post == nil || post.Text != "retweeted" {
+		t.Errorf("Post(4) = %v, %v", post, err)
+	}
+	if ok, err := store.HasPost(retweeted); err != nil || ok {
+		t.Errorf("HasPost(retweeted) = %v, %v", ok, err)
+	}
+
+	// 再次保存时更新
+	updated := *posts[2]
+	updated.Text, updated.RepostsCount = "third, edited", 5
+	if err := store.AddPost(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if post, err := store.Post(3); err != nil || post == nil || post.Text != "third, edited" || post.RepostsCount != 5 {
+		t.Errorf("Post(3) after re-adding = %+v, %v", post, err)
+	}
+
+	cst := time.FixedZone("CST", 8*3600)
+	tests := []struct {
+		query *PostQuery
+		want  string
+	}{
+		{&PostQuery{UID: 10}, "3,2,1"},
+		{&PostQuery{UID: 10, Since: time.Date(2024, 5, 1, 11, 30, 0, 0, cst)}, "3,2"},
+		{&PostQuery{UID: 10, Until: time.Date(2024, 5, 1, 11, 30, 0, 0, cst)}, "1"},
+		{&PostQuery{UID: 10, Since: time.Date(2024, 5, 1, 2, 30, 0, 0, time.UTC), Until: time.Date(2024, 5, 1, 23, 0, 0, 0, cst)}, "2"},
+		{&PostQuery{UID: 10, Limit: 2}, "3,2"},
+		{&PostQuery{Text: "second"}, "2"},
+		{&PostQuery{Text: "edited"}, "3"},
+		{&PostQuery{Text: "retweeted"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := store.Posts(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, post := range got {
+			ids = append(ids, post.MblogID)
+		}
+		want := strings.NewReplacer("1", "a", "2", "b", "3", "c").Replace(tt.want)
+		if strings.Join(ids, ",") != want {
+			t.Errorf("Posts(%+v) = %v, want %s", tt.query, ids, want)
+		}
+	}
+
+	if err := store.AddUser(&User{ID: 20, Name: "user", FollowersCount: 12000}); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := store.User(20); err != nil || user == nil || user.Name != "user" || user.FollowersCount != 12000 {
+		t.Errorf("User(20) = %+v, %v", user, err)
+	}
+	if ok, err := store.HasUser(20); err != nil || !ok {
+		t.Errorf("HasUser(20) = %v, %v", ok, err)
+	}
+
+	comments := []*Comments{
+		{Id: 101, User: &User{ID: 20, Name: "user"}, Text: "later", CreatedAt: "Wed May 01 12:00:00 +0800 2024"},
+		{Id: 100, User: &User{ID: 20, Name: "user"}, Text: "earlier", CreatedAt: "Wed May 01 11:00:00 +0800 2024"},
+		{Id: 99, User: &User{ID: 20, Name: "user"}, Text: "same time", CreatedAt: "Wed May 01 04:00:00 +0000 2024"},
+	}
+	if err := store.AddComments(2, comments); err != nil {
+		t.Fatal(err)
+	}
+	edited := *comments[1]
+	edited.Text = "earlier, edited"
+	if err := store.AddComments(2, []*Comments{&edited}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Comments(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, c := range got {
+		createdAt, err := ParseCreatedAt(c.CreatedAt, time.Now(), ChinaTimeZone)
+		if err != nil {
+			t.Errorf("comment %d created at %q: %v", c.Id, c.CreatedAt, err)
+		}
+		order = append(order, fmt.Sprintf("%d@%s:%s", c.Id, createdAt.In(ChinaTimeZone).Format("15:04"), c.Text))
+	}
+	if want := "[100@11:00:earlier, edited 99@12:00:same time 101@12:00:later]"; fmt.Sprint(order) != want {
+		t.Errorf("Comments(2) = %v, want %s", order, want)
+	}
+
+	media := []*Media{
+		{ID: 1, Kind: JobPic, Idx: 0, Pid: "pid1", URL: "https://wx1.sinaimg.cn/large/pid1.jpg", Key: "pid1.jpg", Size: 100, SHA256: "aa"},
+		{ID: 1, Kind: JobPic, Idx: 0, Pid: "pid1", URL: "https://wx1.sinaimg.cn/large/pid1.jpg", Key: "pid1.jpg", Size: 200, SHA256: "bb"},
+	}
+	if err := store.AddMedia(media); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Media(1); err != nil || len(got) != 1 || got[0].Size != 200 || got[0].Key != "pid1.jpg" {
+		t.Errorf("Media(1) = %+v, %v", got, err)
+	}
+}
+
+// 版本7之前sqlite按传入时的时区保存时间
+func TestSQLiteUTCMigration(t *testing.T) {
+	database := &Database{DN: "sqlite", DSN: t.TempDir() + "/weibo.db"}
+	defer database.Close()
+	if err := database.MigrateTo(6); err != nil {
+		t.Fatal(err)
+	}
+	db, _ := database.getdb()
+	for _, row := range [][]any{
+		{1, "a", "2024-05-01 10:00:00 +0800 +0800"},
+		{2, "b", "2024-05-01 03:30:00 +0000 UTC"},
+		{3, "c", "2024-05-01 09:00:00 -0700 -0700"},
+	} {
+		if _, err := db.DB.Exec("INSERT INTO post(ID, MblogID, CreatedAt, Archived) VALUES(?,?,?,?)", append(row, true)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := database.Posts(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, post := range posts {
+		ids = append(ids, post.MblogID)
+	}
+	if got := strings.Join(ids, ","); got != "c,b,a" {
+		t.Errorf("Posts = %s, want c,b,a", got)
+	}
+	if want := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC); len(posts) == 3 && !posts[2].CreatedAt.Equal(want) {
+		t.Errorf("CreatedAt = %s, want %s", posts[2].CreatedAt, want)
+	}
+}
+
+func TestDialect(t *testing.T) {
+	rebinds := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM post WHERE ID = ? AND UID = ?", "SELECT * FROM post WHERE ID = $1 AND UID = $2"},
+		{"SELECT '?' FROM post WHERE Text LIKE '%?%' AND ID = ?", "SELECT '?' FROM post WHERE Text LIKE '%?%' AND ID = $1"},
+		{"SELECT 'it''s ?' FROM post WHERE ID IN (?,?)", "SELECT 'it''s ?' FROM post WHERE ID IN ($1,$2)"},
+	}
+	for _, tt := range rebinds {
+		if got := postgresDialect.rebind(tt.query); got != tt.want {
+			t.Errorf("postgres rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+		if got := mysqlDialect.rebind(tt.query); got != tt.query {
+			t.Errorf("mysql rebind(%q) = %q", tt.query, got)
+		}
+	}
+
+	keys, cols := []string{"ID", "At"}, []string{"Name", "Size"}
+	statements := []struct {
+		d            *dialect
+		insertIgnore string
+		upsert       string
+	}{
+		{mysqlDialect,
+			"INSERT IGNORE INTO t(ID, At, Name, Size) VALUES(?,?,?,?)",
+			"INSERT INTO t(ID, At, Name, Size) VALUES(?,?,?,?) ON DUPLICATE KEY UPDATE Name = VALUES(Name), Size = VALUES(Size)"},
+		{sqliteDialect,
+			"INSERT INTO t(ID, At, Name, Size) VALUES(?,?,?,?) ON CONFLICT(ID, At) DO NOTHING",
+			"INSERT INTO t(ID, At, Name, Size) VALUES(?,?,?,?) ON CONFLICT(ID, At) DO UPDATE SET Name = excluded.Name, Size = excluded.Size"},
+		{postgresDialect,
+			"INSERT INTO t(ID, At, Name, Size) VALUES(?,?,?,?) ON CONFLICT(ID, At) DO NOTHING",
+			"INSERT INTO t(ID, At, Name, Size) VALUES(?,?,?,?) ON CONFLICT(ID, At) DO UPDATE SET Name = excluded.Name, Size = excluded.Size"},
+	}
+	for _, tt := range statements {
+		if got := tt.d.insertIgnore("t", keys, cols); got != tt.insertIgnore {
+			t.Errorf("%s insertIgnore = %q, want %q", tt.d.name, got, tt.insertIgnore)
+		}
+		if got := tt.d.upsert("t", keys, cols); got != tt.upsert {
+			t.Errorf("%s upsert = %q, want %q", tt.d.name, got, tt.upsert)
+		}
+		if got := tt.d.upsert("t", keys, nil); got != tt.d.insertIgnore("t", keys, nil) {
+			t.Errorf("%s upsert without columns = %q", tt.d.name, got)
+		}
+	}
+	if got, want := postgresDialect.rebind(postgresDialect.upsert("t", keys, cols)), "INSERT INTO t(ID, At, Name, Size) VALUES($1,$2,$3,$4) ON CONFLICT(ID, At) DO UPDATE SET Name = excluded.Name, Size = excluded.Size"; got != want {
+		t.Errorf("postgres rebound upsert = %q, want %q", got, want)
+	}
+
+	stmt := "CREATE TABLE t (At {datetime}, Ok {bool}, Data {json}, Raw {longjson}, Compressed {blob})"
+	ddls := []struct {
+		d    *dialect
+		want string
+	}{
+		{mysqlDialect, "CREATE TABLE t (At DATETIME, Ok BOOLEAN, Data TEXT, Raw LONGTEXT, Compressed LONGBLOB)"},
+		{sqliteDialect, "CREATE TABLE t (At DATETIME, Ok BOOLEAN, Data TEXT, Raw TEXT, Compressed BLOB)"},
+		{postgresDialect, "CREATE TABLE t (At TIMESTAMPTZ, Ok BOOLEAN, Data JSONB, Raw JSONB, Compressed BYTEA)"},
+	}
+	for _, tt := range ddls {
+		if got := tt.d.ddl(stmt); got != tt.want {
+			t.Errorf("%s ddl = %q, want %q", tt.d.name, got, tt.want)
+		}
+	}
+
+	for dn, want := range map[string]*dialect{"mysql": mysqlDialect, "sqlite": sqliteDialect, "sqlite3": sqliteDialect, "postgres": postgresDialect, "pgx": postgresDialect} {
+		if got := dialectOf(dn); got != want {
+			t.Errorf("dialectOf(%s) = %s, want %s", dn, got.name, want.name)
+		}
+	}
+}
+
+// TestPostgresStore 需要一个空的PostgreSQL数据库，例如
+// WEIBO_TEST_POSTGRES_DSN="postgres://localhost/weibo_test?sslmode=disable"，结束时回滚全部迁移
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("WEIBO_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("WEIBO_TEST_POSTGRES_DSN not set")
+	}
+	database := &Database{DN: "postgres", DSN: dsn}
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Rollback(len(migrations)); err != nil {
+			t.Error(err)
+		}
+		database.Close()
+	})
+	testStore(t, database)
+
+	// 原始JSON按原样保存，内容相同的再次抓取不保存
+	raw := json.RawMessage(`{"z":1,  "a":[1,2]}`)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := database.AddPayload(&RawPayload{Kind: PayloadPost, ID: 1, FetchedAt: at.Add(time.Duration(i) * time.Hour), Data: raw}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if payloads, err := database.Payloads(PayloadPost, 1); err != nil || len(payloads) != 1 || string(payloads[0].Data) != string(raw) {
+		t.Errorf("Payloads = %+v, %v", payloads, err)
+	}
+}
@@ -0,0 +1,192 @@
+package weibo
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaKey 描述一个媒体文件，用于按布局模板生成存储路径
+type MediaKey struct {
+	Kind      string // pic、motion、video、cover
+	UID       int64
+	ID        int64
+	MblogID   string
+	CreatedAt time.Time
+	Idx       int    // 在博文中的序号，从0开始
+	Name      string // pid或视频id
+	Ext       string // 不含"."
+}
+
+// 默认布局与早期版本一致，所有文件平铺在同一目录下
+const DefaultLayout = "{name}.{ext}"
+
+// RenderLayout 渲染布局模板，支持{uid}、{id}、{mblogid}、{idx}、{name}、{pid}、{kind}、
+// {ext}、{yyyy}、{mm}、{dd}，例如"{uid}/{yyyy}/{mm}/{mblogid}_{idx}.{ext}"；
+// 视频封面与同序号的图片扩展名相同，模板中没有{kind}、{name}或{pid}时文件名加上"_cover"
+func RenderLayout(layout string, k *MediaKey) string {
+	if layout == "" {
+		layout = DefaultLayout
+	}
+	created := k.CreatedAt.In(ChinaTimeZone)
+	yyyy, mm, dd := "0000", "00", "00"
+	if !k.CreatedAt.IsZero() {
+		yyyy, mm, dd = created.Format("2006"), created.Format("01"), created.Format("02")
+	}
+	if k.Kind == JobCover && !strings.Contains(layout, "{kind}") && !strings.Contains(layout, "{name}") && !strings.Contains(layout, "{pid}") {
+		if strings.HasSuffix(layout, ".{ext}") {
+			layout = strings.TrimSuffix(layout, ".{ext}") + "_{kind}.{ext}"
+		} else {
+			layout += "_{kind}"
+		}
+	}
+	return strings.NewReplacer(
+		"{uid}", strconv.FormatInt(k.UID, 10),
+		"{id}", strconv.FormatInt(k.ID, 10),
+		"{mblogid}", k.MblogID,
+		"{idx}", strconv.Itoa(k.Idx),
+		"{name}", k.Name,
+		"{pid}", k.Name,
+		"{kind}", k.Kind,
+		"{ext}", k.Ext,
+		"{yyyy}", yyyy,
+		"{mm}", mm,
+		"{dd}", dd,
+	).Replace(layout)
+}
+
+// MediaStore 媒体文件的存储，key为"/"分隔的相对路径
+type MediaStore interface {
+	// Key 按存储的布局生成key
+	Key(k *MediaKey) string
+	Exists(key string) (bool, error)
+	// Put 将本地文件file存入key，成功后file可能已被移走
+	Put(key string, file string) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// List 返回以prefix开头的全部key
+	List(prefix string) ([]string, error)
+}
+
+// findKey 扩展名未知时依次尝试exts，返回已存在的key
+func findKey(store MediaStore, k *MediaKey, exts []string) (string, bool, error) {
+	key := *k
+	for _, ext := range exts {
+		key.Ext = strings.TrimPrefix(ext, ".")
+		name := store.Key(&key)
+		if ok, err := store.Exists(name); err != nil {
+			return "", false, err
+		} else if ok {
+			return name, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// LocalStore 本地文件系统存储
+type LocalStore struct {
+	Root   string
+	Layout string // 默认DefaultLayout
+}
+
+func (s *LocalStore) Key(k *MediaKey) string {
+	return filepath.ToSlash(filepath.Clean(RenderLayout(s.Layout, k)))
+}
+
+// Path 返回key对应的本地路径
+func (s *LocalStore) Path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(key))
+}
+
+func (s *LocalStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(s.Path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStore) Put(key string, file string) error {
+	name := s.Path(key)
+	if err := mkdir(filepath.Dir(name)); err != nil {
+		return err
+	}
+	if err := os.Rename(file, name); err == nil {
+		return os.Chmod(name, 0644)
+	}
+
+	// 跨设备时先复制到同目录的临时文件再重命名
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	src.Close()
+	return os.Remove(file)
+}
+
+func (s *LocalStore) Open(key string) (io.ReadCloser, error) {
+	return os.Open(s.Path(key))
+}
+
+func (s *LocalStore) Delete(key string) error {
+	err := os.Remove(s.Path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.Root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") && name != s.Root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) && !strings.HasSuffix(key, ".part") && !strings.HasSuffix(key, ".tmp") {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
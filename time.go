@@ -0,0 +1,130 @@
+package weibo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PC端接口created_at的格式
+const TimeLayout = "Mon Jan 02 15:04:05 -0700 2006"
+
+// 微博默认时区
+var ChinaTimeZone = time.FixedZone("CST", 8*3600)
+
+var relativeTimeRe = regexp.MustCompile(`^(\d+)\s*(秒|分钟|小时|天)前$`)
+
+// ParseCreatedAt 解析博文时间，支持PC端的"Mon Jan 02 15:04:05 -0700 2006"，
+// 以及手机端的"刚刚"、"5分钟前"、"今天 12:30"、"昨天 08:00"、"03-14"、"2023-03-14"等，
+// 相对时间以now为基准，不带时区的时间按loc解析
+func ParseCreatedAt(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if loc == nil {
+		loc = ChinaTimeZone
+	}
+	now = now.In(loc)
+
+	if t, err := time.Parse(TimeLayout, s); err == nil {
+		return t, nil
+	}
+	if s == "刚刚" {
+		return now, nil
+	}
+	if m := relativeTimeRe.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		unit := map[string]time.Duration{"秒": time.Second, "分钟": time.Minute, "小时": time.Hour, "天": 24 * time.Hour}[m[2]]
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+
+	for prefix, days := range map[string]int{"今天": 0, "昨天": 1, "前天": 2} {
+		if strings.HasPrefix(s, prefix) {
+			clock, err := time.ParseInLocation("15:04", strings.TrimSpace(strings.TrimPrefix(s, prefix)), loc)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("parse created_at %q: %w", s, err)
+			}
+			day := now.AddDate(0, 0, -days)
+			return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc), nil
+		}
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	// 今年的博文省略年份，晚于now时视为去年，02-29取最近的闰年
+	for _, layout := range []string{"01-02 15:04", "01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			for year := now.Year(); ; year-- {
+				created := time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+				if created.Day() == t.Day() && !created.After(now) {
+					return created, nil
+				}
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("parse created_at %q: unknown format", s)
+}
+
+func (c *Client) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+func (c *Client) location() *time.Location {
+	if c.Location != nil {
+		return c.Location
+	}
+	return ChinaTimeZone
+}
+
+// prepareMblog 补全博文及其转发博文的id和时间
+func (c *Client) prepareMblog(mblog *Mblog) {
+	fillMblogID(mblog)
+	for m := mblog; m != nil; m = m.Retweeted {
+		if m.CreatedTime.IsZero() && m.CreatedAt != "" {
+			m.CreatedTime, _ = ParseCreatedAt(m.CreatedAt, c.now(), c.location())
+		}
+	}
+}
+
+func (c *Client) prepareCMblog(mblog *CMblog) {
+	fillCMblogID(mblog)
+	for m := mblog; m != nil; m = m.Retweeted {
+		if m.CreatedTime.IsZero() && m.CreatedAt != "" {
+			m.CreatedTime, _ = ParseCreatedAt(m.CreatedAt, c.now(), c.location())
+		}
+	}
+}
+
+// prepareComments 解析评论及其回复的时间
+func (c *Client) prepareComments(comments []*Comments) {
+	for _, comment := range comments {
+		if comment == nil {
+			continue
+		}
+		if comment.CreatedTime.IsZero() && comment.CreatedAt != "" {
+			comment.CreatedTime, _ = ParseCreatedAt(comment.CreatedAt, c.now(), c.location())
+		}
+		c.prepareComments(comment.Comments)
+		c.prepareComments([]*Comments{comment.ReplyComment})
+	}
+}
+
+// createdTime 返回客户端已解析的时间，未解析时相对时间以抓取时间为基准，
+// 不是由接口抓取的则以当前时间为基准
+func createdTime(parsed time.Time, s string, fetched *Fetched) time.Time {
+	if !parsed.IsZero() || s == "" {
+		return parsed
+	}
+	now := time.Now()
+	if fetched != nil && !fetched.At.IsZero() {
+		now = fetched.At
+	}
+	t, _ := ParseCreatedAt(s, now, ChinaTimeZone)
+	return t
+}
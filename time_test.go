@@ -0,0 +1,109 @@
+package weibo
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseCreatedAt(t *testing.T) {
+	cst := ChinaTimeZone
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, cst)
+	tests := []struct {
+		s    string
+		now  time.Time
+		loc  *time.Location
+		want time.Time
+	}{
+		{"Tue Jan 02 09:00:00 +0800 2024", now, nil, time.Date(2024, 1, 2, 9, 0, 0, 0, cst)},
+		{"Mon Jan 01 20:00:00 -0500 2024", now, nil, time.Date(2024, 1, 2, 9, 0, 0, 0, cst)},
+		{"刚刚", now, nil, now},
+		{"30秒前", now, nil, now.Add(-30 * time.Second)},
+		{"5分钟前", now, nil, time.Date(2024, 1, 2, 9, 55, 0, 0, cst)},
+		{"11小时前", now, nil, time.Date(2024, 1, 1, 23, 0, 0, 0, cst)},
+		{"3天前", now, nil, time.Date(2023, 12, 30, 10, 0, 0, 0, cst)},
+		{"今天 08:30", now, nil, time.Date(2024, 1, 2, 8, 30, 0, 0, cst)},
+		{"昨天 23:10", now, nil, time.Date(2024, 1, 1, 23, 10, 0, 0, cst)},
+		{"前天 00:05", now, nil, time.Date(2023, 12, 31, 0, 5, 0, 0, cst)},
+		// now为UTC时按loc取日期
+		{"今天 08:30", time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), cst, time.Date(2024, 1, 2, 8, 30, 0, 0, cst)},
+		{"01-01 08:00", now, nil, time.Date(2024, 1, 1, 8, 0, 0, 0, cst)},
+		{"01-02", now, nil, time.Date(2024, 1, 2, 0, 0, 0, 0, cst)},
+		{"12-31 23:59", now, nil, time.Date(2023, 12, 31, 23, 59, 0, 0, cst)},
+		{"01-03", now, nil, time.Date(2023, 1, 3, 0, 0, 0, 0, cst)},
+		{"02-29", time.Date(2024, 3, 1, 0, 0, 0, 0, cst), nil, time.Date(2024, 2, 29, 0, 0, 0, 0, cst)},
+		{"02-29 12:00", time.Date(2025, 3, 1, 0, 0, 0, 0, cst), nil, time.Date(2024, 2, 29, 12, 0, 0, 0, cst)},
+		{"02-29", time.Date(2027, 1, 1, 0, 0, 0, 0, cst), nil, time.Date(2024, 2, 29, 0, 0, 0, 0, cst)},
+		{"2023-03-14", now, nil, time.Date(2023, 3, 14, 0, 0, 0, 0, cst)},
+		{"2023-03-14 12:30", now, nil, time.Date(2023, 3, 14, 12, 30, 0, 0, cst)},
+		{"2023-03-14 12:30:05", now, time.UTC, time.Date(2023, 3, 14, 12, 30, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseCreatedAt(tt.s, tt.now, tt.loc)
+		if err != nil {
+			t.Errorf("ParseCreatedAt(%q, %s): %v", tt.s, tt.now, err)
+		} else if !got.Equal(tt.want) {
+			t.Errorf("ParseCreatedAt(%q, %s) = %s, want %s", tt.s, tt.now, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "abc", "今天 25:00", "13-01", "2023/03/14"} {
+		if got, err := ParseCreatedAt(s, now, nil); err == nil {
+			t.Errorf("ParseCreatedAt(%q) = %s, want error", s, got)
+		}
+	}
+}
+
+// 相对时间以Clock为基准
+func TestClientClock(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, ChinaTimeZone)
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ajax/statuses/show":
+			w.Write([]byte(`{"ok":1,"id":1,"mblogid":"1","created_at":"5分钟前","retweeted_status":{"id":2,"created_at":"昨天 08:00"}}`))
+		case "/ajax/statuses/buildComments":
+			w.Write([]byte(`{"ok":1,"data":[{"id":10,"created_at":"刚刚","comments":[{"id":11,"created_at":"1分钟前"}]}]}`))
+		}
+	}))
+	client.Clock = func() time.Time { return now }
+
+	mblog, err := client.GetMblog("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := mblog.Post()
+	if want := now.Add(-5 * time.Minute); !post.CreatedAt.Equal(want) {
+		t.Errorf("CreatedAt = %s, want %s", post.CreatedAt, want)
+	}
+	if want := time.Date(2024, 1, 1, 8, 0, 0, 0, ChinaTimeZone); !post.Retweeted.CreatedAt.Equal(want) {
+		t.Errorf("Retweeted.CreatedAt = %s, want %s", post.Retweeted.CreatedAt, want)
+	}
+
+	// 没有解析过的博文以抓取时间为基准
+	mblog.CreatedTime = time.Time{}
+	if post := mblog.Post(); !post.CreatedAt.Equal(now.Add(-5 * time.Minute)) {
+		t.Errorf("CreatedAt from the fetch time = %s", post.CreatedAt)
+	}
+
+	body, err := client.GetComments(1, 1, "1", 0, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := newTestDatabase(t)
+	if err := database.AddComments(1, body.Data); err != nil {
+		t.Fatal(err)
+	}
+	comments, err := database.Comments(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range comments {
+		createdAt, _ := ParseCreatedAt(c.CreatedAt, time.Time{}, nil)
+		got = append(got, fmt.Sprintf("%d@%s", c.Id, createdAt.In(ChinaTimeZone).Format("15:04")))
+	}
+	if fmt.Sprint(got) != "[11@09:59 10@10:00]" {
+		t.Errorf("comments = %v, want [11@09:59 10@10:00]", got)
+	}
+}
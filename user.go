@@ -0,0 +1,102 @@
+package weibo
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type UserDetail struct {
+	Birthday       string          `json:"birthday"`
+	CreatedAt      string          `json:"created_at"`  // 注册时间，例如"2010-06-01"
+	IPLocation     string          `json:"ip_location"` // 例如"IP属地：北京"
+	Description    string          `json:"description"`
+	Gender         string          `json:"gender"`
+	Education      *Education      `json:"education,omitempty"`
+	Career         *Career         `json:"career,omitempty"`
+	SunshineCredit *SunshineCredit `json:"sunshine_credit,omitempty"`
+	LabelDesc      []*LabelDesc    `json:"label_desc,omitempty"`
+	CreatedTime    time.Time       `json:"-"` // 解析后的CreatedAt
+	Fetched        *Fetched        `json:"-"`
+}
+
+type Education struct {
+	School string `json:"school"`
+}
+
+type Career struct {
+	Company string `json:"company"`
+}
+
+type SunshineCredit struct {
+	Level string `json:"level"`
+}
+
+type LabelDesc struct {
+	Name string `json:"name"`
+}
+
+// IPRegion 返回去掉"IP属地："前缀的地区
+func (d *UserDetail) IPRegion() string {
+	if d == nil {
+		return ""
+	}
+	region := strings.TrimPrefix(d.IPLocation, "IP属地：")
+	return strings.TrimSpace(region)
+}
+
+type ProfileInfoBody struct {
+	Data struct {
+		User *User `json:"user"`
+	} `json:"data"`
+	Ok int `json:"ok"`
+}
+
+type ProfileDetailBody struct {
+	Data *UserDetail `json:"data"`
+	Ok   int         `json:"ok"`
+}
+
+// GetUser 获取用户资料，包括profile/info和profile/detail
+func (c *Client) GetUser(uid string) (*User, error) {
+	return c.getUser(fmt.Sprintf("https://weibo.com/ajax/profile/info?uid=%s", url.QueryEscape(uid)))
+}
+
+// GetUserByName 通过昵称获取用户资料
+func (c *Client) GetUserByName(screenName string) (*User, error) {
+	return c.getUser(fmt.Sprintf("https://weibo.com/ajax/profile/info?screen_name=%s", url.QueryEscape(screenName)))
+}
+
+func (c *Client) getUser(infoUrl string) (*User, error) {
+	body := &ProfileInfoBody{}
+	if err := c.getJSON(infoUrl, body); err != nil {
+		return nil, err
+	} else if body.Ok != 1 || body.Data.User == nil {
+		return nil, fmt.Errorf("body not ok")
+	}
+	user := body.Data.User
+
+	detail, err := c.GetUserDetail(strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		return nil, err
+	}
+	user.Detail = detail
+	return user, nil
+}
+
+// GetUserDetail 获取用户的注册时间、IP属地、教育和工作经历等
+func (c *Client) GetUserDetail(uid string) (*UserDetail, error) {
+	detailUrl := fmt.Sprintf("https://weibo.com/ajax/profile/detail?uid=%s", url.QueryEscape(uid))
+	body := &ProfileDetailBody{}
+	if err := c.getJSON(detailUrl, body); err != nil {
+		return nil, err
+	} else if body.Ok != 1 || body.Data == nil {
+		return nil, fmt.Errorf("body not ok")
+	}
+	if body.Data.CreatedAt != "" {
+		body.Data.CreatedTime, _ = ParseCreatedAt(body.Data.CreatedAt, c.now(), c.location())
+	}
+	return body.Data, nil
+}
@@ -0,0 +1,43 @@
+package weibo
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetUser(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ajax/profile/info":
+			if r.URL.Query().Get("screen_name") != "名字" {
+				t.Errorf("info query = %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"ok":1,"data":{"user":{"id":10,"screen_name":"名字","followers_count":"1051.5万","friends_count":12,"statuses_count":"3000","verified":true,"verified_type":0}}}`))
+		case "/ajax/profile/detail":
+			if r.URL.Query().Get("uid") != "10" {
+				t.Errorf("detail query = %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"ok":1,"data":{"created_at":"2010-06-01","ip_location":"IP属地：北京","birthday":"1990-01-01"}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+		}
+	}))
+
+	user, err := client.GetUserByName("名字")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 10 || user.FollowersCount != 10515000 || user.FriendsCount != 12 || user.StatusesCount != 3000 || !user.Verified {
+		t.Errorf("user = %+v", user)
+	}
+	if user.Detail == nil {
+		t.Fatal("detail not fetched")
+	}
+	if want := time.Date(2010, 6, 1, 0, 0, 0, 0, ChinaTimeZone); !user.Detail.CreatedTime.Equal(want) {
+		t.Errorf("CreatedTime = %s, want %s", user.Detail.CreatedTime, want)
+	}
+	if region := user.Detail.IPRegion(); region != "北京" {
+		t.Errorf("IPRegion = %q", region)
+	}
+}
@@ -0,0 +1,280 @@
+package weibo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PageInfo 博文卡片，object_type或type为video时是视频
+type PageInfo struct {
+	Type       string            `json:"type,omitempty"` // 手机端
+	ObjectType string            `json:"object_type"`
+	ObjectID   string            `json:"object_id"`
+	PageTitle  string            `json:"page_title,omitempty"`
+	PagePic    *PagePic          `json:"page_pic,omitempty"`
+	MediaInfo  *MediaInfo        `json:"media_info,omitempty"`
+	Urls       map[string]string `json:"urls,omitempty"`       // 手机端各清晰度地址
+	PlayCount  Count             `json:"play_count,omitempty"` // 手机端，例如"12万次播放"
+}
+
+// PagePic 卡片封面，PC端视频卡片的page_pic为地址字符串，其他为对象
+type PagePic struct {
+	URL string `json:"url"`
+}
+
+func (p *PagePic) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &p.URL)
+	}
+	type pagePic PagePic
+	return json.Unmarshal(data, (*pagePic)(p))
+}
+
+type MediaInfo struct {
+	Name              string      `json:"name,omitempty"`
+	MediaID           string      `json:"media_id,omitempty"`
+	Duration          float64     `json:"duration,omitempty"`
+	StreamURL         string      `json:"stream_url,omitempty"`
+	StreamURLHd       string      `json:"stream_url_hd,omitempty"`
+	Mp4SdURL          string      `json:"mp4_sd_url,omitempty"`
+	Mp4HdURL          string      `json:"mp4_hd_url,omitempty"`
+	Mp4720pMp4        string      `json:"mp4_720p_mp4,omitempty"`
+	OnlineUsersNumber Count       `json:"online_users_number,omitempty"`
+	PlaybackList      []*Playback `json:"playback_list,omitempty"`
+}
+
+type Playback struct {
+	Meta struct {
+		Label        string `json:"label"`
+		QualityLabel string `json:"quality_label"`
+	} `json:"meta"`
+	PlayInfo struct {
+		URL     string `json:"url"`
+		Width   int    `json:"width"`
+		Height  int    `json:"height"`
+		Bitrate int    `json:"bitrate"`
+		Size    int64  `json:"size"`
+	} `json:"play_info"`
+}
+
+// Video 统一后的视频信息，Qualities按清晰度从高到低排列
+type Video struct {
+	ID        string          `json:"id"`
+	Title     string          `json:"title,omitempty"`
+	Duration  float64         `json:"duration"` // 秒
+	Cover     string          `json:"cover,omitempty"`
+	PlayCount Count           `json:"play_count"`
+	Qualities []*VideoQuality `json:"qualities"`
+}
+
+type VideoQuality struct {
+	Label   string `json:"label"`
+	URL     string `json:"url"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Bitrate int    `json:"bitrate,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+}
+
+// 没有宽高时按标签估计清晰度
+var videoLabelHeight = map[string]int{
+	"mp4_1080p": 1080, "mp4_1080p_mp4": 1080, "1080p": 1080,
+	"mp4_720p": 720, "mp4_720p_mp4": 720, "720p": 720, "stream_url_hd": 720,
+	"mp4_hd": 540, "mp4_hd_mp4": 540, "mp4_hd_url": 540,
+	"mp4_ld": 360, "mp4_ld_mp4": 360, "mp4_sd_url": 360, "stream_url": 360,
+}
+
+// Best 返回清晰度最高的地址
+func (v *Video) Best() (*VideoQuality, error) {
+	if v == nil || len(v.Qualities) == 0 {
+		return nil, MediaNotFound
+	}
+	return v.Qualities[0], nil
+}
+
+func (p *PageInfo) isVideo() bool {
+	return p != nil && (p.ObjectType == "video" || p.Type == "video")
+}
+
+// Video 解析视频卡片
+func (p *PageInfo) Video() (*Video, error) {
+	if !p.isVideo() {
+		return nil, fmt.Errorf("page info is not video: %w", MediaNotFound)
+	}
+	video := &Video{ID: p.ObjectID, Title: p.PageTitle, PlayCount: p.PlayCount}
+	if p.PagePic != nil {
+		video.Cover = p.PagePic.URL
+	}
+
+	seen := make(map[string]bool)
+	add := func(q *VideoQuality) {
+		if q.URL == "" || seen[q.URL] {
+			return
+		}
+		seen[q.URL] = true
+		if q.Height == 0 {
+			q.Height = videoLabelHeight[strings.ToLower(q.Label)]
+		}
+		video.Qualities = append(video.Qualities, q)
+	}
+
+	if m := p.MediaInfo; m != nil {
+		if m.MediaID != "" {
+			video.ID = m.MediaID
+		}
+		if video.Title == "" {
+			video.Title = m.Name
+		}
+		video.Duration = m.Duration
+		if video.PlayCount == 0 {
+			video.PlayCount = m.OnlineUsersNumber
+		}
+		for _, pb := range m.PlaybackList {
+			add(&VideoQuality{Label: pb.Meta.Label, URL: pb.PlayInfo.URL, Width: pb.PlayInfo.Width, Height: pb.PlayInfo.Height,
+				Bitrate: pb.PlayInfo.Bitrate, Size: pb.PlayInfo.Size})
+		}
+		add(&VideoQuality{Label: "mp4_720p_mp4", URL: m.Mp4720pMp4})
+		add(&VideoQuality{Label: "stream_url_hd", URL: m.StreamURLHd})
+		add(&VideoQuality{Label: "mp4_hd_url", URL: m.Mp4HdURL})
+		add(&VideoQuality{Label: "mp4_sd_url", URL: m.Mp4SdURL})
+		add(&VideoQuality{Label: "stream_url", URL: m.StreamURL})
+	}
+	for label, u := range p.Urls {
+		add(&VideoQuality{Label: label, URL: u})
+	}
+
+	sort.SliceStable(video.Qualities, func(i, j int) bool {
+		a, b := video.Qualities[i], video.Qualities[j]
+		if a.Height != b.Height {
+			return a.Height > b.Height
+		}
+		return a.Bitrate > b.Bitrate
+	})
+	if len(video.Qualities) == 0 {
+		return nil, fmt.Errorf("video %s: %w", video.ID, MediaNotFound)
+	}
+	return video, nil
+}
+
+// Videos 返回博文的视频，包括page_info和mix_media_info中的视频
+func (m *Mblog) Videos() ([]*Video, error) {
+	if m == nil {
+		return nil, nil
+	}
+	var videos []*Video
+	var errs []error
+	if m.PageInfo.isVideo() {
+		if video, err := m.PageInfo.Video(); err != nil {
+			errs = append(errs, err)
+		} else {
+			videos = append(videos, video)
+		}
+	}
+	if m.MixMediaInfo != nil {
+		for _, item := range m.MixMediaInfo.Items {
+			if item.Type != "video" {
+				continue
+			}
+			pageInfo, err := item.Video()
+			if err == nil {
+				var video *Video
+				if video, err = pageInfo.Video(); err == nil {
+					videos = append(videos, video)
+				}
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return videos, errors.Join(errs...)
+}
+
+// videos 忽略解析失败的视频
+func (m *Mblog) videos() []*Video {
+	videos, _ := m.Videos()
+	return videos
+}
+
+func (m *CMblog) videos() []*Video {
+	videos, _ := m.Videos()
+	return videos
+}
+
+// Videos 返回手机端博文的视频
+func (m *CMblog) Videos() ([]*Video, error) {
+	if m == nil || !m.PageInfo.isVideo() {
+		return nil, nil
+	}
+	video, err := m.PageInfo.Video()
+	if err != nil {
+		return nil, err
+	}
+	return []*Video{video}, nil
+}
+
+// DownVideos 下载清晰度最高的视频为path+id+".mp4"，封面保存为path+id+".jpg"，已存在的跳过，
+// progress可为nil，total未知时为-1
+func (c *Client) DownVideos(videos []*Video, path string, progress func(video *Video, written, total int64)) error {
+	for _, video := range videos {
+		if err := c.DownVideo(video, path, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) DownVideo(video *Video, path string, progress func(video *Video, written, total int64)) error {
+	quality, err := video.Best()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+
+	if video.Cover != "" {
+		if _, ok := ExistedPic(path, video.ID); !ok {
+			if err := DownPic(c, video.ID, video.Cover, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	name := path + video.ID + ".mp4"
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+
+	var fileProgress func(written, total int64)
+	if progress != nil {
+		fileProgress = func(written, total int64) { progress(video, written, total) }
+	}
+	return c.downFile(quality.URL, name, fileProgress)
+}
+
+// downFile 下载视频到name，name包含扩展名
+func (c *Client) downFile(fileUrl string, name string, progress func(written, total int64)) error {
+	ext := filepath.Ext(name)
+	_, err := c.fetch(fileUrl, strings.TrimSuffix(name, ext), &FetchOptions{Kind: "video", Ext: ext, Progress: progress})
+	return err
+}
+
+type progressWriter struct {
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.progress != nil {
+		w.progress(w.written, w.total)
+	}
+	return len(p), nil
+}
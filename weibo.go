@@ -9,37 +9,67 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var BadRequest = errors.New("BadRequest")
 
+// Unauthorized cookie无效或已过期，接口返回401、403或跳转到登录页
+var Unauthorized = errors.New("Unauthorized")
+
+// ErrMaxPages 连续翻页达到MaxPages时仍有未获取的博文
+var ErrMaxPages = errors.New("max pages reached")
+
 type User struct {
 	ID     int64  `json:"id"`
 	Name   string `json:"screen_name"`
 	Icon   string `json:"avatar_large"`
 	Remark string `json:"remark"`
+
+	// 以下字段来自profile/info，博文中的user通常不完整
+	Description    string      `json:"description,omitempty"`
+	Gender         string      `json:"gender,omitempty"` // m-男；f-女
+	Location       string      `json:"location,omitempty"`
+	FollowersCount Count       `json:"followers_count,omitempty"` // 手机端为"1051.5万"之类的字符串
+	FriendsCount   Count       `json:"friends_count,omitempty"`
+	StatusesCount  Count       `json:"statuses_count,omitempty"`
+	Verified       bool        `json:"verified,omitempty"`
+	VerifiedType   int         `json:"verified_type,omitempty"` // -1-未认证；0-个人认证；1~7-机构认证
+	VerifiedReason string      `json:"verified_reason,omitempty"`
+	Mbrank         int         `json:"mbrank,omitempty"` // 会员等级
+	Mbtype         int         `json:"mbtype,omitempty"` // 会员类型
+	Svip           int         `json:"svip,omitempty"`
+	Detail         *UserDetail `json:"detail,omitempty"` // 来自profile/detail
+	Fetched        *Fetched    `json:"-"`
 }
 
 type Mblog struct {
-	User         *User                  `json:"user"`
-	CreatedAt    string                 `json:"created_at"`
-	ID           int64                  `json:"id"`
-	MblogID      string                 `json:"mblogid"`
-	TextRaw      string                 `json:"text_raw"`
-	Text         string                 `json:"text"`
-	IsLongText   bool                   `json:"isLongText"`
-	PicNum       int8                   `json:"pic_num"`
-	PicIds       []string               `json:"pic_ids"`
-	PicInfos     map[string]interface{} `json:"pic_infos"`
-	MixMediaInfo map[string]interface{} `json:"mix_media_info"`
-	Retweeted    *Mblog                 `json:"retweeted_status,omitempty"`
-	Source       string                 `json:"source"`
-	RegionName   string                 `json:"region_name"`
-	Ok           int                    `json:"ok,omitempty"`
-	LongTextRaw  string
+	User           *User               `json:"user"`
+	CreatedAt      string              `json:"created_at"`
+	ID             int64               `json:"id"`
+	MblogID        string              `json:"mblogid"`
+	TextRaw        string              `json:"text_raw"`
+	Text           string              `json:"text"`
+	IsLongText     bool                `json:"isLongText"`
+	PicNum         int8                `json:"pic_num"`
+	PicIds         []string            `json:"pic_ids"`
+	PicInfos       map[string]*PicInfo `json:"pic_infos"`
+	MixMediaInfo   *MixMediaInfo       `json:"mix_media_info"`
+	PageInfo       *PageInfo           `json:"page_info,omitempty"`
+	Retweeted      *Mblog              `json:"retweeted_status,omitempty"`
+	Source         string              `json:"source"`
+	RegionName     string              `json:"region_name"`
+	UrlStruct      []UrlStruct         `json:"url_struct,omitempty"`
+	TopicStruct    []*TopicStruct      `json:"topic_struct,omitempty"`
+	RepostsCount   Count               `json:"reposts_count"`
+	CommentsCount  Count               `json:"comments_count"`
+	AttitudesCount Count               `json:"attitudes_count"`
+	Ok             int                 `json:"ok,omitempty"`
+	LongTextRaw    string
+	CreatedTime    time.Time `json:"-"`
+	Fetched        *Fetched  `json:"-"`
 }
 
 func (m *Mblog) TheText() string {
@@ -49,33 +79,6 @@ func (m *Mblog) TheText() string {
 	return m.TextRaw
 }
 
-func (m *Mblog) PicUrls() map[string]interface{} {
-	if m == nil {
-		return nil
-	}
-	var pics map[string]interface{}
-	pics = make(map[string]interface{})
-	if m.PicNum > 0 {
-		if m.PicInfos != nil {
-			for _, pic := range m.PicIds {
-				var picUrl string
-				picUrl, _ = m.PicInfos[pic].(map[string]interface{})["largest"].(map[string]interface{})["url"].(string)
-				pics[pic] = picUrl
-			}
-		} else if m.MixMediaInfo != nil {
-			items := m.MixMediaInfo["items"].([]interface{})
-			for _, item := range items {
-				if item.(map[string]interface{})["type"] == "pic" {
-					pic := item.(map[string]interface{})["id"]
-					picUrl := item.(map[string]interface{})["data"].(map[string]interface{})["largest"].(map[string]interface{})["url"].(string)
-					pics[pic.(string)] = picUrl
-				}
-			}
-		}
-	}
-	return pics
-}
-
 func (mblog *Mblog) String() string {
 	text := strings.ReplaceAll(mblog.TextRaw, "\n", "\\n")
 	if len([]rune(text)) > 50 {
@@ -86,11 +89,39 @@ func (mblog *Mblog) String() string {
 
 type MymblogBody struct {
 	Data struct {
-		List []*Mblog `json:"list"`
+		List  []*Mblog `json:"list"`
+		Total int      `json:"total,omitempty"`
 	} `json:"data"`
 	Ok int `json:"ok"`
 }
 
+// Feature 博文类型筛选，取值与mymblog接口的feature参数一致
+type Feature int
+
+const (
+	FeatureAll      Feature = 0 // 全部
+	FeatureOriginal Feature = 1 // 原创
+	FeaturePicture  Feature = 2 // 图片
+	FeatureVideo    Feature = 3 // 视频
+	FeatureMusic    Feature = 4 // 音乐、文章
+)
+
+// searchProfile接口的筛选参数
+func (f Feature) query() string {
+	switch f {
+	case FeatureOriginal:
+		return "hasori=1&hasret=0&hastext=1&haspic=1&hasvideo=1&hasmusic=1"
+	case FeaturePicture:
+		return "hasori=1&hasret=1&hastext=0&haspic=1&hasvideo=0&hasmusic=0"
+	case FeatureVideo:
+		return "hasori=1&hasret=1&hastext=0&haspic=0&hasvideo=1&hasmusic=0"
+	case FeatureMusic:
+		return "hasori=1&hasret=1&hastext=0&haspic=0&hasvideo=0&hasmusic=1"
+	default:
+		return "hasori=1&hasret=1&hastext=1&haspic=1&hasvideo=1&hasmusic=1"
+	}
+}
+
 type LongtextBody struct {
 	Data struct {
 		LongTextContent string `json:"longTextContent"`
@@ -99,11 +130,19 @@ type LongtextBody struct {
 }
 
 type Client struct {
-	Cookie string
-	Proxy  string
-	Check  checkCookie
+	Cookie    string
+	Proxy     string
+	Check     checkCookie
+	Clock     func() time.Time  // 解析相对时间的基准，默认time.Now
+	Location  *time.Location    // 解析不带时区时间的时区，默认ChinaTimeZone
+	Sleep     time.Duration     // 连续翻页时每页之间的间隔
+	MaxPages  int               // 连续翻页的最多页数，默认DefaultMaxPages
+	Transport http.RoundTripper // 发送请求的Transport，默认按Proxy创建
 }
 
+// 连续翻页默认的最多页数
+const DefaultMaxPages = 100
+
 type checkCookie struct {
 	Check        bool   `default:"false"`      // 是否检查cookie
 	Checked      bool   `default:"false"`      // 判断已检查了cookie的标志位
@@ -156,15 +195,20 @@ func (c *Client) AddFriend(uid string) (err error) {
 	return nil
 }
 
-func (c *Client) postJSON(_url string, data any) error {
-	client := &http.Client{}
-	if c.Proxy != "" {
+func (c *Client) httpClient() *http.Client {
+	client := &http.Client{Transport: c.Transport}
+	if client.Transport == nil && c.Proxy != "" {
 		if proxyUrl, err := url.Parse(c.Proxy); err == nil {
 			client.Transport = &http.Transport{
 				Proxy: http.ProxyURL(proxyUrl),
 			}
 		}
 	}
+	return client
+}
+
+func (c *Client) postJSON(_url string, data any) error {
+	client := c.httpClient()
 
 	jsonData, err := json.Marshal(data)
 	req, err := http.NewRequest("POST", _url, bytes.NewBuffer(jsonData))
@@ -200,14 +244,7 @@ func (c *Client) postJSON(_url string, data any) error {
 }
 
 func (c *Client) getJSON(_url string, body any) error {
-	client := &http.Client{}
-	if c.Proxy != "" {
-		if proxyUrl, err := url.Parse(c.Proxy); err == nil {
-			client.Transport = &http.Transport{
-				Proxy: http.ProxyURL(proxyUrl),
-			}
-		}
-	}
+	client := c.httpClient()
 
 	req, err := http.NewRequest("GET", _url, nil)
 	if err != nil {
@@ -227,6 +264,9 @@ func (c *Client) getJSON(_url string, body any) error {
 	if res.StatusCode == http.StatusBadRequest {
 		return BadRequest
 	}
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden || isLoginPage(res.Request.URL) {
+		return Unauthorized
+	}
 
 	data, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -235,98 +275,92 @@ func (c *Client) getJSON(_url string, body any) error {
 	if err := json.Unmarshal(data, body); err != nil {
 		return err
 	}
+	if r, ok := body.(recorder); ok {
+		if u, err := url.Parse(_url); err == nil {
+			r.record(data, u.Host+u.Path, c.now())
+		}
+	}
 	return nil
 }
 
+// isLoginPage 请求是否被跳转到了登录页
+func isLoginPage(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	return host == "passport.weibo.com" || host == "login.sina.com.cn" || strings.HasPrefix(u.Path, "/login")
+}
+
 func (c *Client) DownPics(mblog *Mblog, path string) error {
-	if mblog.PicNum > 0 {
-		client := &http.Client{}
-		if c.Proxy != "" {
-			if proxyUrl, err := url.Parse(c.Proxy); err == nil {
-				client.Transport = &http.Transport{
-					Proxy: http.ProxyURL(proxyUrl),
-				}
-			}
-		}
-		ExistedOrDownPic(c, mblog.Retweeted, path)
-		ExistedOrDownPic(c, mblog, path)
-	}
-	return nil
+	report, err := (&Downloader{Client: c}).DownPics(mblog, path)
+	return errors.Join(err, report.Err())
 }
 
 func ExistedOrDownPic(c *Client, mblog *Mblog, path string) error {
 	if mblog != nil {
-		picUrls := mblog.PicUrls()
-		for _, pic := range mblog.PicIds {
-			if _, err := os.Stat(path + pic + ".jpg"); err == nil {
-				continue
-			}
-			if err := DownPic(c, pic, picUrls[pic].(string), path); err != nil {
+		pics, picsErr := mblog.Pictures()
+		for _, pic := range pics {
+			picUrl, err := pic.Best()
+			if err != nil {
 				return err
 			}
+			if _, ok := ExistedPic(path, pic.PicID); !ok {
+				if err := DownPicType(c, pic.PicID, picUrl.URL, pic.Type, path); err != nil {
+					return err
+				}
+			}
+			if pic.IsLivePhoto() {
+				if err := DownLivePhotoMotion(c, pic.PicID, pic.Video, path); err != nil {
+					return err
+				}
+			}
 		}
+		return picsErr
 	}
 	return nil
 }
 
 func (c *Client) DownPicsByUrl(name []string, urls []string, path string) error {
-	if len(urls) > 0 {
-		client := &http.Client{}
-		if c.Proxy != "" {
-			if proxyUrl, err := url.Parse(c.Proxy); err == nil {
-				client.Transport = &http.Transport{
-					Proxy: http.ProxyURL(proxyUrl),
-				}
-			}
-		}
-		for i, url := range urls {
-			DownPic(c, name[i], url, path)
-		}
+	if len(name) != len(urls) {
+		return fmt.Errorf("down pics: %d names for %d urls", len(name), len(urls))
 	}
-	return nil
+	var jobs []*DownloadJob
+	for i, url := range urls {
+		jobs = append(jobs, &DownloadJob{Kind: JobPic, Name: name[i], URL: url, Path: path})
+	}
+	return (&Downloader{Client: c}).Download(jobs).Err()
 }
 
 func DownPic(c *Client, pic string, picUrl string, path string) error {
-	client := &http.Client{}
-	if c.Proxy != "" {
-		if proxyUrl, err := url.Parse(c.Proxy); err == nil {
-			client.Transport = &http.Transport{
-				Proxy: http.ProxyURL(proxyUrl),
-			}
-		}
-	}
+	return DownPicType(c, pic, picUrl, "", path)
+}
 
-	req, err := http.NewRequest("GET", picUrl, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:107.0) Gecko/20100101 Firefox/107.0")
-	req.Header.Set("Host", "weibo.com")
-	req.Header.Set("Cookie", c.Cookie)
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("referer", "https://weibo.com/")
+// DownPicType 下载图片并按实际类型保存扩展名，picType为pic_infos中的type
+func DownPicType(c *Client, pic string, picUrl string, picType string, path string) error {
+	_, err := downPic(c, pic, picUrl, picType, path)
+	return err
+}
 
-	res, err := client.Do(req)
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
-	_, err = os.Stat(path)
-	if os.IsNotExist(err) {
-		err := os.MkdirAll(path, os.ModePerm)
-		if err != nil {
-			return err
-		}
-	}
-	picname := path + pic + ".jpg"
-	err = os.WriteFile(picname, data, 666)
+// downPic 返回保存的文件名
+func downPic(c *Client, pic string, picUrl string, picType string, path string) (string, error) {
+	result, err := c.fetch(picUrl, path+pic, &FetchOptions{Kind: "image", PicType: picType})
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return result.File, nil
 }
 
+// GetMblog 获取单条博文，mblogId可以是数字id、mblogid或博文链接
 func (c *Client) GetMblog(mblogId string) (*Mblog, error) {
+	if strings.ContainsAny(mblogId, "/.") {
+		ref, err := c.Resolve(mblogId)
+		if err != nil {
+			return nil, err
+		} else if !ref.IsMblog() {
+			return nil, fmt.Errorf("%s is not a mblog link", mblogId)
+		} else if ref.Mblog != nil {
+			return ref.Mblog, nil
+		}
+		mblogId = ref.MblogID
+	}
 	mblogUrl := fmt.Sprintf("https://weibo.com/ajax/statuses/show?id=%s&locale=zh-CN", mblogId)
 	body := &Mblog{}
 	if err := c.getJSON(mblogUrl, body); err != nil {
@@ -334,19 +368,75 @@ func (c *Client) GetMblog(mblogId string) (*Mblog, error) {
 	} else if body.Ok != 1 {
 		return nil, fmt.Errorf("body not ok")
 	}
+	c.prepareMblog(body)
 	return body, nil
 }
 
 func (c *Client) GetMblogs(userid string, page int, longtext bool) ([]*Mblog, error) {
-	blogUrl := fmt.Sprintf("https://weibo.com/ajax/statuses/mymblog?uid=%s&page=%d&feature=0", userid, page)
+	return c.GetMblogsByFeature(userid, page, FeatureAll, longtext)
+}
+
+// GetMblogsByFeature 按类型筛选获取用户博文
+func (c *Client) GetMblogsByFeature(userid string, page int, feature Feature, longtext bool) ([]*Mblog, error) {
+	blogUrl := fmt.Sprintf("https://weibo.com/ajax/statuses/mymblog?uid=%s&page=%d&feature=%d", userid, page, feature)
 	body := &MymblogBody{}
 	if err := c.getJSON(blogUrl, body); err != nil {
 		return nil, err
 	} else if body.Ok != 1 {
 		return nil, fmt.Errorf("body not ok")
 	}
+	return c.fetchLongTexts(body.Data.List, longtext)
+}
+
+// SearchMblogs 按时间范围和类型搜索用户博文，不受翻页深度限制
+func (c *Client) SearchMblogs(userid string, page int, from, to time.Time, feature Feature, longtext bool) ([]*Mblog, error) {
+	blogUrl := fmt.Sprintf("https://weibo.com/ajax/statuses/searchProfile?uid=%s&page=%d&starttime=%d&endtime=%d&%s", userid, page, from.Unix(), to.Unix(), feature.query())
+	body := &MymblogBody{}
+	if err := c.getJSON(blogUrl, body); err != nil {
+		return nil, err
+	} else if body.Ok != 1 {
+		return nil, fmt.Errorf("body not ok")
+	}
+	return c.fetchLongTexts(body.Data.List, longtext)
+}
+
+// GetMblogsBetween 获取用户在[from, to]时间范围内的全部博文，遇到空页或只有已获取博文的页时停止，
+// 翻页达到MaxPages时返回已获取的博文和ErrMaxPages，可缩小时间范围后继续获取
+func (c *Client) GetMblogsBetween(userid string, from, to time.Time, feature Feature, longtext bool) ([]*Mblog, error) {
+	maxPages := c.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
 	var mblogs []*Mblog
-	for _, v := range body.Data.List {
+	seen := map[int64]bool{}
+	for page := 1; ; page++ {
+		if page > maxPages {
+			return mblogs, fmt.Errorf("get mblogs of %s between %s and %s: %w", userid, from.Format(time.DateTime), to.Format(time.DateTime), ErrMaxPages)
+		}
+		if page > 1 && c.Sleep > 0 {
+			time.Sleep(c.Sleep)
+		}
+		_mblogs, err := c.SearchMblogs(userid, page, from, to, feature, longtext)
+		if err != nil {
+			return nil, err
+		}
+		n := len(mblogs)
+		for _, mblog := range _mblogs {
+			if !seen[mblog.ID] {
+				seen[mblog.ID] = true
+				mblogs = append(mblogs, mblog)
+			}
+		}
+		if len(mblogs) == n {
+			return mblogs, nil
+		}
+	}
+}
+
+func (c *Client) fetchLongTexts(list []*Mblog, longtext bool) ([]*Mblog, error) {
+	var mblogs []*Mblog
+	for _, v := range list {
+		c.prepareMblog(v)
 		if longtext {
 			if err := c.FetchMblogLongText(v); err != nil {
 				return nil, err
@@ -392,19 +482,26 @@ func (c *Client) FetchMblogLongText(mblog *Mblog) error {
 	}
 }
 
+// Database 博文的SQL存储，DN为mysql、sqlite或postgres，其他驱动按mysql语法处理
 type Database struct {
-	DN  string
-	DSN string
-	db  *sql.DB
+	DN   string
+	DSN  string
+	Gzip bool // 原始JSON以gzip压缩保存
+	db   *sqlDB
 }
 
-func (database *Database) getdb() (*sql.DB, error) {
+func (database *Database) getdb() (*sqlDB, error) {
 	if database.db == nil {
 		db, err := sql.Open(database.DN, database.DSN)
 		if err != nil {
 			return nil, err
 		}
-		database.db = db
+		d := dialectOf(database.DN)
+		if d == sqliteDialect {
+			// sqlite同时只能有一个写入
+			db.SetMaxOpenConns(1)
+		}
+		database.db = &sqlDB{DB: db, dialect: d}
 	}
 	return database.db, nil
 }
@@ -416,24 +513,34 @@ func (database *Database) Close() {
 	}
 }
 
-func (database *Database) Migrate() error {
-	db, err := database.getdb()
-	if err != nil {
+func (database *Database) HasMblog(mblog *Mblog) (bool, error) {
+	return database.HasPost(mblog.Post())
+}
+
+// AddMblog 保存博文，由接口获取的博文同时保存原始JSON
+func (database *Database) AddMblog(mblog *Mblog) error {
+	if err := database.AddPost(mblog.Post()); err != nil {
 		return err
 	}
-	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS mblog (UID BIGINT NOT NULL, ID BIGINT NOT NULL, MblogID VARCHAR(64) NOT NULL, TheText TEXT, Pics TEXT, CreatedAt CHAR(32), RetweetedUID BIGINT NOT NULL, RetweetedID BIGINT NOT NULL, RetweetedMblogID VARCHAR(64) NOT NULL, RetweetedTheText TEXT, RetweetedPics TEXT, RetweetedCreatedAt CHAR(32), PRIMARY KEY (UID,ID,MblogID))"); err != nil {
+	return database.AddPayload(mblog.Payload())
+}
+
+// AddCMblog 保存手机端博文，由接口获取的博文同时保存原始JSON
+func (database *Database) AddCMblog(mblog *CMblog) error {
+	if err := database.AddPost(mblog.Post()); err != nil {
 		return err
 	}
-	return nil
+	return database.AddPayload(mblog.Payload())
 }
 
-func (database *Database) HasMblog(mblog *Mblog) (bool, error) {
+// HasPost 博文是否已抓取，只作为转发保存的博文不算
+func (database *Database) HasPost(post *Post) (bool, error) {
 	db, err := database.getdb()
 	if err != nil {
 		return false, err
 	}
 
-	rows, err := db.Query("SELECT UID, ID, MblogID FROM mblog WHERE UID = ? AND ID = ? AND MblogID = ?", mblog.User.ID, mblog.ID, mblog.MblogID)
+	rows, err := db.Query("SELECT ID FROM post WHERE ID = ? AND Archived = ?", post.ID, true)
 	if err != nil {
 		return false, err
 	}
@@ -445,42 +552,223 @@ func (database *Database) HasMblog(mblog *Mblog) (bool, error) {
 	return false, nil
 }
 
-func (database *Database) AddMblog(mblog *Mblog) error {
+// AddPost 保存博文及其转发的博文，已存在时更新
+func (database *Database) AddPost(post *Post) error {
+	return database.transact(func(tx *sqlTx) error {
+		return writePostTree(tx, tx.dialect, post)
+	})
+}
+
+// writePostTree 保存博文、转发的博文以及它们的实体和实况照片
+func writePostTree(x sqlExecer, d *dialect, post *Post) error {
+	if post.Retweeted != nil {
+		if err := writePost(x, d, post.Retweeted, saveRetweeted); err != nil {
+			return err
+		}
+	}
+	if err := writePost(x, d, post, saveArchived); err != nil {
+		return err
+	}
+	for p := post; p != nil; p = p.Retweeted {
+		if err := writeEntities(x, p.ID, p.Entities); err != nil {
+			return err
+		}
+		if err := writeLivePhotos(x, d, p.ID, p.LivePhotos()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddLivePhotos 记录实况照片下载后的文件名或key，通常来自DownloadReport.LivePhotos
+func (database *Database) AddLivePhotos(livePhotos []*LivePhoto) error {
+	return database.transact(func(tx *sqlTx) error {
+		stmt := tx.dialect.upsert("livephoto", []string{"ID", "Pid"}, []string{"Still", "Motion", "VideoURL"})
+		for _, lp := range livePhotos {
+			if _, err := tx.Exec(stmt, lp.ID, lp.Pid, lp.Still, lp.Motion, lp.VideoURL); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeLivePhotos 保存博文中的实况照片，保留已记录的文件名
+func writeLivePhotos(x sqlExecer, d *dialect, id int64, livePhotos []*LivePhoto) error {
+	var pids []any
+	for _, lp := range livePhotos {
+		if _, err := x.Exec(d.insertIgnore("livephoto", []string{"ID", "Pid"}, []string{"Still", "Motion", "VideoURL"}),
+			lp.ID, lp.Pid, lp.Still, lp.Motion, lp.VideoURL); err != nil {
+			return err
+		}
+		if _, err := x.Exec("UPDATE livephoto SET VideoURL = ? WHERE ID = ? AND Pid = ?", lp.VideoURL, lp.ID, lp.Pid); err != nil {
+			return err
+		}
+		pids = append(pids, lp.Pid)
+	}
+	// 删除博文中已不存在的实况照片
+	stmt := "DELETE FROM livephoto WHERE ID = ?"
+	if len(pids) > 0 {
+		stmt += " AND Pid NOT IN (?" + strings.Repeat(",?", len(pids)-1) + ")"
+	}
+	_, err := x.Exec(stmt, append([]any{id}, pids...)...)
+	return err
+}
+
+func (database *Database) LivePhotos(id int64) ([]*LivePhoto, error) {
 	db, err := database.getdb()
 	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT ID, Pid, Still, Motion, VideoURL FROM livephoto WHERE ID = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var livePhotos []*LivePhoto
+	for rows.Next() {
+		lp := &LivePhoto{}
+		if err := rows.Scan(&lp.ID, &lp.Pid, &lp.Still, &lp.Motion, &lp.VideoURL); err != nil {
+			return nil, err
+		}
+		livePhotos = append(livePhotos, lp)
+	}
+	return livePhotos, rows.Err()
+}
+
+// AddEntities 保存博文的实体，已有的实体会被替换
+func (database *Database) AddEntities(id int64, entities []*Entity) error {
+	return database.transact(func(tx *sqlTx) error {
+		return writeEntities(tx, id, entities)
+	})
+}
+
+func writeEntities(x sqlExecer, id int64, entities []*Entity) error {
+	if _, err := x.Exec("DELETE FROM mblog_entity WHERE ID = ?", id); err != nil {
 		return err
 	}
+	for i, e := range entities {
+		if _, err := x.Exec("INSERT INTO mblog_entity(ID, Idx, Type, Text, StartOffset, EndOffset, Name, UID, URL, LongURL, Title) VALUES(?,?,?,?,?,?,?,?,?,?,?)",
+			id, i, e.Type, e.Text, e.Start, e.End, e.Name, e.UID, e.URL, e.LongURL, e.Title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	var uid, id int64
-	var mblogID, theText, createdAt, pics, rePics string
-	var picUrls, rePicUrls []string
-	if mblog.Retweeted != nil {
-		if mblog.Retweeted.User != nil {
-			uid = mblog.Retweeted.User.ID
-		} else {
-			uid = -1
+// Entities 返回博文的实体
+func (database *Database) Entities(id int64) ([]*Entity, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT Type, Text, StartOffset, EndOffset, Name, UID, URL, LongURL, COALESCE(Title, '') FROM mblog_entity WHERE ID = ? ORDER BY Idx", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entities []*Entity
+	for rows.Next() {
+		e := &Entity{}
+		if err := rows.Scan(&e.Type, &e.Text, &e.Start, &e.End, &e.Name, &e.UID, &e.URL, &e.LongURL, &e.Title); err != nil {
+			return nil, err
 		}
-		id = mblog.Retweeted.ID
-		mblogID = mblog.Retweeted.MblogID
-		theText = mblog.Retweeted.TheText()
-		createdAt = mblog.Retweeted.CreatedAt
-		urls := mblog.Retweeted.PicUrls()
-		for _, picUrl := range urls {
-			rePicUrls = append(picUrls, picUrl.(string))
-			rePicBytes, _ := json.Marshal(rePicUrls)
-			rePics = string(rePicBytes)
+		entities = append(entities, e)
+	}
+	return entities, rows.Err()
+}
+
+// MblogsByEntity 返回包含指定实体的博文id，例如某个话题或@某个用户的博文
+func (database *Database) MblogsByEntity(entityType string, name string) ([]int64, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT DISTINCT ID FROM mblog_entity WHERE Type = ? AND Name = ?", entityType, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RecentMblogIDs 返回发布时间不早于since的博文mblogid
+func (database *Database) RecentMblogIDs(since time.Time) ([]string, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT MblogID FROM post WHERE Archived = ? AND CreatedAt >= ? ORDER BY CreatedAt DESC", true, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mblogIDs []string
+	for rows.Next() {
+		var mblogID string
+		if err := rows.Scan(&mblogID); err != nil {
+			return nil, err
 		}
+		mblogIDs = append(mblogIDs, mblogID)
 	}
-	urls := mblog.PicUrls()
-	for _, picUrl := range urls {
-		picUrls = append(picUrls, picUrl.(string))
-		picBytes, _ := json.Marshal(picUrls)
-		pics = string(picBytes)
+	return mblogIDs, rows.Err()
+}
+
+// AddSnapshot 保存快照，mysql的DATETIME只精确到秒，时间截断到秒，同一秒内的快照只保留最后一次
+func (database *Database) AddSnapshot(snapshot *Snapshot) error {
+	db, err := database.getdb()
+	if err != nil {
+		return err
 	}
-	if _, err := db.Exec("INSERT INTO mblog(UID, ID, MblogID, TheText, Pics, CreatedAt, RetweetedUID, RetweetedID, "+
-		"RetweetedMblogID, RetweetedTheText, RetweetedPics, RetweetedCreatedAt) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
-		mblog.User.ID, mblog.ID, mblog.MblogID, mblog.TheText(), pics, mblog.CreatedAt, uid, id, mblogID, theText, rePics, createdAt); err != nil {
+
+	stmt := db.dialect.upsert("mblog_snapshot", []string{"ID", "At"}, []string{"MblogID", "RepostsCount", "CommentsCount", "AttitudesCount"})
+	if _, err := db.Exec(stmt, snapshot.ID, snapshot.At.Truncate(time.Second), snapshot.MblogID,
+		snapshot.RepostsCount, snapshot.CommentsCount, snapshot.AttitudesCount); err != nil {
 		return err
 	}
 	return nil
 }
+
+// Snapshots 按时间顺序返回博文的互动数快照，即增长曲线，mysql需要在dsn中设置parseTime=true
+func (database *Database) Snapshots(id int64) ([]*Snapshot, error) {
+	db, err := database.getdb()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT ID, MblogID, At, RepostsCount, CommentsCount, AttitudesCount FROM mblog_snapshot WHERE ID = ? ORDER BY At", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*Snapshot
+	for rows.Next() {
+		snapshot := &Snapshot{}
+		if err := rows.Scan(&snapshot.ID, &snapshot.MblogID, &snapshot.At, &snapshot.RepostsCount, &snapshot.CommentsCount, &snapshot.AttitudesCount); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,51 @@
+package weibo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetMblogsBetween(t *testing.T) {
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, ChinaTimeZone)
+	to := time.Date(2024, 5, 1, 0, 0, 0, 0, ChinaTimeZone)
+	tests := []struct {
+		name     string
+		pages    map[int]string
+		maxPages int
+		want     string
+		err      error
+	}{
+		{"empty page", map[int]string{1: `[{"id":2},{"id":1}]`}, 0, "[2 1]", nil},
+		{"repeated page", map[int]string{1: `[{"id":2}]`, 2: `[{"id":1}]`, 3: `[{"id":1}]`}, 0, "[2 1]", nil},
+		{"max pages", map[int]string{1: `[{"id":3}]`, 2: `[{"id":2}]`, 3: `[{"id":1}]`}, 2, "[3 2]", ErrMaxPages},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				list := tt.pages[page]
+				if list == "" {
+					list = "[]"
+				}
+				fmt.Fprintf(w, `{"ok":1,"data":{"list":%s}}`, list)
+			}))
+			client.MaxPages = tt.maxPages
+
+			mblogs, err := client.GetMblogsBetween("1", from, to, FeatureAll, false)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			var ids []int64
+			for _, mblog := range mblogs {
+				ids = append(ids, mblog.ID)
+			}
+			if got := fmt.Sprint(ids); got != tt.want {
+				t.Errorf("mblogs = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}